@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	conf "github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/service"
+)
+
+const usage = `Usage: doormanlb [command] [flags]
+
+Commands:
+  serve                 run the load balancer (the default)
+  validate              check a config file and exit
+  explain <url|path>    show how a request would be handled
+  help                  show this help
+
+Flags default to the PORT, CONFIG_PATH and REDIS_URL environment variables.
+Run "doormanlb <command> -h" for the flags of a command.
+`
+
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", envOrDefault("CONFIG_PATH", defaultConfigPath), "config file, in JSON, YAML or TOML (CONFIG_PATH)")
+}
+
+// parseFlags parses flags that may come before or after the positional
+// arguments, and returns the positional ones.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// validate loads and validates a config file, for use in CI. It returns the
+// process exit code.
+func validate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := configFlag(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) > 0 {
+		fmt.Fprintf(stderr, "validate takes no arguments, got %q\n", positional)
+		return 2
+	}
+
+	if _, err := conf.Load(*configPath); err != nil {
+		fmt.Fprintf(stderr, "invalid config: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s is valid\n", *configPath)
+	return 0
+}
+
+// explain prints how a request for a URL or path would be handled: the endpoint
+// it resolves to, its cache key, pool and lifetimes. It returns the process
+// exit code.
+func explain(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := configFlag(flags)
+	host := flags.String("host", "localhost", "Host header of the request, unless the URL names one")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(stderr, "explain takes one URL or path, such as /blog/post?lang=en")
+		return 2
+	}
+
+	cfg, err := conf.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid config: %v\n", err)
+		return 1
+	}
+	request, err := explainRequest(positional[0], *host)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid URL %q: %v\n", positional[0], err)
+		return 2
+	}
+
+	hostPattern, key := cfg.MatchEndpoint(request.Host, request.URL.Path)
+	endpoint := cfg.EndpointFor(request.Host, request.URL.Path)
+	poolName := endpoint.Pool
+	if poolName == "" {
+		poolName = conf.DefaultPoolKey
+	}
+	pool := cfg.Pool(poolName)
+	resolved, err := json.MarshalIndent(endpoint, "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "encoding endpoint: %v\n", err)
+		return 1
+	}
+
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	if hostPattern != "" {
+		fmt.Fprintf(table, "host\t%s\n", hostPattern)
+	}
+	fmt.Fprintf(table, "endpoint\t%s\n", key)
+	fmt.Fprintf(table, "behavior\t%s\n", endpoint.CacheBehavior)
+	fmt.Fprintf(table, "cache key\t%s\n", service.CacheKey(cfg, request, endpoint))
+	fmt.Fprintf(table, "pool\t%s (%s: %s)\n", poolName, pool.Strategy, strings.Join(pool.ServiceURLs(), ", "))
+	fmt.Fprintf(table, "ttl\t%s\n", describeTTL(endpoint))
+	if endpoint.CacheBehavior == conf.CacheBehaviorCache || endpoint.CacheBehavior == conf.CacheBehaviorCoalesce {
+		fmt.Fprintf(table, "lock ttl\t%s\n", service.LockTTL(endpoint))
+	}
+	if endpoint.CacheBehavior == conf.CacheBehaviorCache {
+		fmt.Fprintf(table, "follower wait\t%s over at most %d attempts\n", service.FollowerMaxWait(endpoint), service.CacheAttempts(endpoint))
+	}
+	_ = table.Flush()
+	fmt.Fprintf(stdout, "resolved config:\n%s\n", resolved)
+	return 0
+}
+
+// explainRequest builds the GET request that target, a URL or a path, stands for.
+func explainRequest(target, host string) (*http.Request, error) {
+	if !strings.HasPrefix(target, "/") {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Host == "" {
+			return nil, fmt.Errorf("expected a path starting with / or an absolute URL")
+		}
+		host, target = parsed.Host, parsed.RequestURI()
+	}
+	return http.NewRequest(http.MethodGet, "http://"+host+target, nil)
+}
+
+func describeTTL(endpoint conf.EndpointConfig) string {
+	if endpoint.CacheBehavior != conf.CacheBehaviorCache {
+		return "not cached"
+	}
+	ttl := endpoint.CacheTTL().String()
+	switch endpoint.TTLMode {
+	case conf.TTLModeUpstream:
+		ttl = fmt.Sprintf("from upstream headers, default %s", ttl)
+	case conf.TTLModeUpstreamCapped:
+		ttl = fmt.Sprintf("from upstream headers, at most %s", ttl)
+	}
+	if stale := endpoint.StaleRetention(); stale > 0 {
+		ttl += fmt.Sprintf(", kept %s longer for stale serving", stale)
+	}
+	return ttl
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return path
+}
+
+const commandsConfig = `{
+  "services": ["http://svc-a:8080"],
+  "strategy": "ROUND_ROBIN",
+  "endpoints": {
+    "DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": "10m"},
+    "/api/*": {"cacheBehavior": "PASSTHROUGH", "pool": "api"},
+    "/blog/*": {"ignoreParameters": true, "staleWhileRevalidate": "1m"},
+    "/feed/*": {"cacheBehavior": "COALESCE", "lockTTL": "5s"}
+  },
+  "pools": {
+    "api": {"services": ["http://api-1:8080", "http://api-2:8080"], "strategy": "LEAST_CONNECTIONS"}
+  }
+}`
+
+func TestValidateReportsInvalidConfigs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	path := writeConfig(t, commandsConfig)
+	if code := validate([]string{"--config", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected a valid config, got exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), path+" is valid") {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+
+	stdout.Reset()
+	path = writeConfig(t, `{"services": ["http://svc-a:8080"], "strategy": "RANDOM"}`)
+	if code := validate([]string{"--config", path}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for an invalid config, got %d", code)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "invalid config") {
+		t.Fatalf("expected the error on stderr, got stdout %q stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestExplainDescribesTheRequest(t *testing.T) {
+	path := writeConfig(t, commandsConfig)
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{
+			[]string{"/blog/post?utm_source=mail&lang=en", "--config", path},
+			[]string{"endpoint       /blog/*", "behavior       CACHE", "cache key      ", "pool           DEFAULT (ROUND_ROBIN: http://svc-a:8080)", "ttl            10m0s, kept 1m0s longer", "lock ttl       30s", "follower wait  1m30s over at most 3 attempts", `"expireTimeout": "10m0s"`},
+		},
+		{
+			[]string{"/feed/latest", "--config", path},
+			[]string{"endpoint   /feed/*", "behavior   COALESCE", "ttl        not cached", "lock ttl   5s"},
+		},
+		{
+			[]string{"--config", path, "http://example.com/api/users"},
+			[]string{"endpoint   /api/*", "behavior   PASSTHROUGH", "pool       api (LEAST_CONNECTIONS: http://api-1:8080, http://api-2:8080)", "ttl        not cached"},
+		},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := explain(test.args, &stdout, &stderr); code != 0 {
+			t.Fatalf("%v: expected exit code 0, got %d: %s", test.args, code, stderr.String())
+		}
+		for _, want := range test.want {
+			if !strings.Contains(stdout.String(), want) {
+				t.Fatalf("%v: expected output to contain %q, got\n%s", test.args, want, stdout.String())
+			}
+		}
+	}
+}
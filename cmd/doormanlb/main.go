@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,44 +30,74 @@ const (
 )
 
 func main() {
-	port := envOrDefault("PORT", defaultPort)
-	configPath := envOrDefault("CONFIG_PATH", defaultConfigPath)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "validate":
+		os.Exit(validate(args, os.Stdout, os.Stderr))
+	case "explain":
+		os.Exit(explain(args, os.Stdout, os.Stderr))
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.String("port", envOrDefault("PORT", defaultPort), "port to listen on (PORT)")
+	configPath := configFlag(flags)
+	redisURL := flags.String("redis-url", os.Getenv("REDIS_URL"), "Redis URL for the REDIS and TIERED cache stores (REDIS_URL)")
+	_ = flags.Parse(args)
 
-	cfg, err := conf.Load(configPath)
+	cfg, err := conf.Load(*configPath)
 	if err != nil {
 		log.Fatalf("loading config: %v", err)
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	watchInterval, err := envDuration("CONFIG_WATCH_INTERVAL")
 	if err != nil {
-		log.Fatalf("creating router: %v", err)
+		log.Fatalf("reading CONFIG_WATCH_INTERVAL: %v", err)
 	}
 
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" && cfg.UsesCache() {
-		redisURL = defaultRedisURL
+	routers, err := updateRouters(cfg, nil)
+	if err != nil {
+		log.Fatalf("creating routers: %v", err)
 	}
 
-	var cacheStore cache.Store
-	if redisURL != "" {
-		cacheStore, err = cache.NewRedisStore(redisURL)
-		if err != nil {
-			log.Fatalf("initializing redis store: %v", err)
-		}
+	cacheStore, closeStore, err := newCacheStore(cfg, *redisURL)
+	if err != nil {
+		log.Fatalf("creating cache store: %v", err)
 	}
 
 	proxyClient := proxy.NewClient()
-	svc := service.NewCachingService(cfg, router, cacheStore, proxyClient)
+	svc := service.NewPooledCachingService(cfg, routers, cacheStore, proxyClient)
+	configReloader := newReloader(*configPath, cfg, routers, svc, cacheStore != nil)
+	defer closeStore()
+	defer configReloader.close()
+	go configReloader.run(watchInterval)
 	h := httpHandler.NewHandler(svc)
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Printf("ADMIN_TOKEN is not set; purging is disabled")
+	}
+	h.RequireAdminToken(adminToken)
 
 	server := &http.Server{
-		Addr:              fmt.Sprintf(":%s", port),
+		Addr:              fmt.Sprintf(":%s", *port),
 		Handler:           h,
 		ReadHeaderTimeout: readHeaderTimeout,
 	}
 
 	go func() {
-		log.Printf("doormanlb listening on :%s", port)
+		log.Printf("doormanlb listening on :%s", *port)
 		if serveErr := server.ListenAndServe(); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
 			log.Fatalf("http server error: %v", serveErr)
 		}
@@ -74,6 +106,124 @@ func main() {
 	shutdown(server)
 }
 
+// newCacheStore creates the configured cache store and a function that releases
+// what the store holds open. An empty redisURL uses the local default when a
+// Redis-backed store is needed.
+func newCacheStore(cfg conf.Config, redisURL string) (cache.Store, func(), error) {
+	storeKind := strings.ToUpper(envOrDefault("CACHE_STORE", cfg.Cache.Store))
+
+	switch storeKind {
+	case conf.CacheStoreMemory:
+		return cache.NewMemoryStore(cfg.Cache.MaxEntries, cfg.Cache.MaxBytes), func() {}, nil
+	case conf.CacheStoreTiered:
+		if redisURL == "" {
+			redisURL = defaultRedisURL
+		}
+		redisStore, err := cache.NewRedisStore(redisURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("initializing redis store: %w", err)
+		}
+		l1 := cache.NewMemoryStore(cfg.Cache.MaxEntries, cfg.Cache.MaxBytes)
+		tieredStore, err := cache.NewTieredStore(l1, redisStore, cfg.Cache.L1TTL())
+		if err != nil {
+			return nil, nil, fmt.Errorf("initializing tiered store: %w", err)
+		}
+		closeStore := func() {
+			if err := tieredStore.Close(); err != nil {
+				log.Printf("closing tiered store: %v", err)
+			}
+		}
+		return tieredStore, closeStore, nil
+	case "", conf.CacheStoreRedis:
+		if redisURL == "" && cfg.UsesCache() {
+			redisURL = defaultRedisURL
+		}
+		if redisURL == "" {
+			return nil, func() {}, nil
+		}
+		redisStore, err := cache.NewRedisStore(redisURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("initializing redis store: %w", err)
+		}
+		return redisStore, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported cache store %q", storeKind)
+	}
+}
+
+// updateRouters returns the started router of each upstream pool, by pool name.
+// Routers in current are updated in place for the pools cfg keeps; the caller
+// closes the ones left over. Every pool is checked before any router changes, so
+// on error the routers in current are left as they were.
+func updateRouters(cfg conf.Config, current map[string]*routing.Router) (map[string]*routing.Router, error) {
+	for _, name := range cfg.PoolNames() {
+		pool := cfg.Pool(name)
+		if err := routing.Validate(routingServices(pool), pool.Strategy); err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	checks := healthChecks(cfg)
+	routers := make(map[string]*routing.Router)
+	for _, name := range cfg.PoolNames() {
+		pool := cfg.Pool(name)
+		router, ok := current[name]
+		if ok {
+			if err := router.Update(routingServices(pool), pool.Strategy); err != nil {
+				return nil, fmt.Errorf("pool %s: %w", name, err)
+			}
+		} else {
+			var err error
+			if router, err = routing.NewWeightedRouter(routingServices(pool), pool.Strategy); err != nil {
+				return nil, fmt.Errorf("pool %s: %w", name, err)
+			}
+		}
+		router.SetOutlierDetection(outlierDetection(cfg))
+		router.StartHealthChecks(checks)
+		routers[name] = router
+	}
+	return routers, nil
+}
+
+func outlierDetection(cfg conf.Config) routing.OutlierDetection {
+	if !cfg.OutlierDetection.Enabled() {
+		return routing.OutlierDetection{}
+	}
+	return routing.OutlierDetection{
+		ConsecutiveFailures: cfg.OutlierDetection.ConsecutiveFailures,
+		BaseEjectionTime:    cfg.OutlierDetection.BaseEjectionDuration(),
+		MaxEjectionTime:     cfg.OutlierDetection.MaxEjectionDuration(),
+		MaxEjectionPercent:  cfg.OutlierDetection.EjectablePercent(),
+	}
+}
+
+func routingServices(pool conf.PoolConfig) []routing.Service {
+	services := make([]routing.Service, 0, len(pool.Services))
+	for _, svc := range pool.Services {
+		services = append(services, routing.Service{URL: svc.URL, Weight: svc.EffectiveWeight()})
+	}
+	return services
+}
+
+func healthChecks(cfg conf.Config) map[string]routing.HealthCheck {
+	checks := make(map[string]routing.HealthCheck)
+	for _, serviceURL := range cfg.AllServiceURLs() {
+		check := cfg.HealthCheck.For(serviceURL)
+		if !check.Enabled() {
+			continue
+		}
+		checks[serviceURL] = routing.HealthCheck{
+			Path:               check.Path,
+			Interval:           check.IntervalDuration(),
+			Timeout:            check.TimeoutDuration(),
+			HealthyThreshold:   check.HealthyAfter(),
+			UnhealthyThreshold: check.UnhealthyAfter(),
+			ExpectedStatus:     check.Status(),
+		}
+	}
+	return checks
+}
+
 func shutdown(server *http.Server) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -87,6 +237,16 @@ func shutdown(server *http.Server) {
 	}
 }
 
+// envDuration parses the duration in the environment variable key, such as
+// "10s". An unset variable is zero.
+func envDuration(key string) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
 func envOrDefault(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	conf "github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/routing"
+	"github.com/robertomachorro/doormanlb/internal/service"
+)
+
+// reloader re-reads the config file and swaps it into the running service. A
+// file that fails to load or validate, or that changes what only a restart can
+// apply, is logged and the old config stays active.
+type reloader struct {
+	path    string
+	service *service.CachingService
+	// hasStore is whether the process started with a cache store.
+	hasStore bool
+
+	mu      sync.Mutex
+	config  conf.Config
+	routers map[string]*routing.Router
+	digest  [sha256.Size]byte
+}
+
+func newReloader(path string, cfg conf.Config, routers map[string]*routing.Router, svc *service.CachingService, hasStore bool) *reloader {
+	r := &reloader{path: path, service: svc, hasStore: hasStore, config: cfg, routers: routers}
+	if contents, err := os.ReadFile(path); err == nil {
+		r.digest = sha256.Sum256(contents)
+	}
+	return r
+}
+
+// reload loads the config file and applies it. Routers of kept pools are updated
+// in place, so in-flight requests and per-service state carry over.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	contents, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("reading config file %q: %w", r.path, err)
+	}
+	cfg, err := conf.Parse(r.path, contents)
+	if err != nil {
+		return err
+	}
+	if err := r.applicable(cfg); err != nil {
+		return err
+	}
+
+	routers, err := updateRouters(cfg, r.routers)
+	if err != nil {
+		return err
+	}
+	r.service.Reload(cfg, routers)
+	for name, router := range r.routers {
+		if _, kept := routers[name]; !kept {
+			router.Close()
+		}
+	}
+	// Only a file applied in full is skipped by the watcher until it changes.
+	r.config, r.routers, r.digest = cfg, routers, sha256.Sum256(contents)
+	return nil
+}
+
+// applicable reports why cfg cannot replace the running config without a
+// restart, if it cannot.
+func (r *reloader) applicable(cfg conf.Config) error {
+	if !reflect.DeepEqual(cfg.Cache, r.config.Cache) {
+		return errors.New("cache settings changed; they only take effect on restart")
+	}
+	if cfg.UsesCache() && !r.hasStore {
+		return errors.New("CACHE endpoints need a cache store, which is only created on restart")
+	}
+	return nil
+}
+
+// changed reports whether the config file differs from the one last loaded. The
+// contents are compared rather than the modification time, so symlink swaps
+// such as Kubernetes ConfigMap updates are noticed too.
+func (r *reloader) changed() bool {
+	contents, err := os.ReadFile(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sha256.Sum256(contents) != r.digest
+}
+
+// run reloads on SIGHUP and, with a positive interval, whenever the file changes.
+func (r *reloader) run(interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-hangups:
+		case <-ticks:
+			if !r.changed() {
+				continue
+			}
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("config reload rejected, keeping the current config: %v", err)
+			continue
+		}
+		log.Printf("config reloaded from %s", r.path)
+	}
+}
+
+func (r *reloader) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, router := range r.routers {
+		router.Close()
+	}
+}
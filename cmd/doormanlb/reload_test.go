@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	conf "github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/service"
+)
+
+func startReloader(t *testing.T, path string, hasStore bool) (*reloader, *service.CachingService) {
+	t.Helper()
+	cfg, err := conf.Load(path)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	routers, err := updateRouters(cfg, nil)
+	if err != nil {
+		t.Fatalf("creating routers: %v", err)
+	}
+	svc := service.NewPooledCachingService(cfg, routers, nil, nil)
+	r := newReloader(path, cfg, routers, svc, hasStore)
+	t.Cleanup(r.close)
+	return r, svc
+}
+
+func rewriteConfig(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+}
+
+const passthroughConfig = `{
+  "services": ["http://svc-a:8080"],
+  "strategy": "ROUND_ROBIN",
+  "endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}}
+}`
+
+func TestReloadRejectedConfigKeepsServing(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"invalid", `{"services": ["http://svc-b:8080"], "strategy": "RANDOM"}`},
+		{"unparseable", `{"services": [`},
+		{"needs a store", `{"services": ["http://svc-b:8080"], "strategy": "ROUND_ROBIN", "endpoints": {"DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": "1m"}}}`},
+		{"cache settings", `{"services": ["http://svc-b:8080"], "strategy": "ROUND_ROBIN", "cache": {"store": "MEMORY"}, "endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}}}`},
+	}
+
+	for _, test := range tests {
+		path := writeConfig(t, passthroughConfig)
+		r, svc := startReloader(t, path, false)
+
+		rewriteConfig(t, path, test.contents)
+		if err := r.reload(); err == nil {
+			t.Fatalf("%s: expected the reload to be rejected", test.name)
+		}
+		if health := svc.UpstreamHealth(); len(health) != 1 || !health["http://svc-a:8080"] {
+			t.Fatalf("%s: expected the previous config to keep serving, got %v", test.name, health)
+		}
+		if !r.changed() {
+			t.Fatalf("%s: expected the rejected file to be retried once it changes", test.name)
+		}
+	}
+}
+
+func TestReloadAppliesChangedFileOnly(t *testing.T) {
+	path := writeConfig(t, passthroughConfig)
+	r, svc := startReloader(t, path, false)
+
+	rewriteConfig(t, path, passthroughConfig)
+	if r.changed() {
+		t.Fatal("expected an unchanged file not to trigger a reload")
+	}
+
+	rewriteConfig(t, path, `{"services": ["http://svc-b:8080"], "strategy": "ROUND_ROBIN", "endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}}}`)
+	if !r.changed() {
+		t.Fatal("expected the new file to trigger a reload")
+	}
+	if err := r.reload(); err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	if health := svc.UpstreamHealth(); len(health) != 1 || !health["http://svc-b:8080"] {
+		t.Fatalf("expected the new services, got %v", health)
+	}
+	if r.changed() {
+		t.Fatal("expected the reloaded file not to trigger another reload")
+	}
+}
+
+func TestReloadClosesDroppedRouters(t *testing.T) {
+	var probes atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		probes.Add(1)
+	}))
+	defer upstream.Close()
+
+	path := writeConfig(t, `{
+  "services": ["http://svc-a:8080"],
+  "strategy": "ROUND_ROBIN",
+  "endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}, "/api/*": {"pool": "api"}},
+  "pools": {"api": {"services": ["`+upstream.URL+`"], "strategy": "ROUND_ROBIN"}},
+  "healthCheck": {"services": {"`+upstream.URL+`": {"path": "/health", "interval": "5ms"}}}
+}`)
+	r, _ := startReloader(t, path, false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for probes.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the api pool to be probed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rewriteConfig(t, path, passthroughConfig)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	if _, kept := r.routers["api"]; kept {
+		t.Fatal("expected the api pool to be dropped")
+	}
+
+	closed := probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	if probes.Load() != closed {
+		t.Fatal("expected the dropped pool's router to stop probing")
+	}
+}
+
+func TestReloadRestoresNodesNoLongerHealthChecked(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	services := `"services": ["` + down.URL + `", "http://svc-a:8080"], "strategy": "ROUND_ROBIN", "endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}}`
+	path := writeConfig(t, `{`+services+`, "healthCheck": {"services": {"`+down.URL+`": {"path": "/health", "interval": "5ms", "unhealthyThreshold": 1}}}}`)
+	r, svc := startReloader(t, path, false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for svc.UpstreamHealth()[down.URL] {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failing service to be marked unhealthy")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rewriteConfig(t, path, `{`+services+`}`)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	if !svc.UpstreamHealth()[down.URL] {
+		t.Fatal("expected a service no longer health checked to be back in rotation")
+	}
+}
+
+func TestUpdateRoutersLeavesRoutersUnchangedOnError(t *testing.T) {
+	current, err := updateRouters(conf.Config{Services: []conf.Service{{URL: "http://svc-a:8080"}}, Strategy: conf.StrategyRoundRobin}, nil)
+	if err != nil {
+		t.Fatalf("creating routers: %v", err)
+	}
+	defer current[conf.DefaultPoolKey].Close()
+
+	cfg := conf.Config{
+		Services: []conf.Service{{URL: "http://svc-b:8080"}},
+		Strategy: conf.StrategyRoundRobin,
+		Pools:    map[string]conf.PoolConfig{"api": {Services: []conf.Service{{URL: "http://api:8080"}}, Strategy: "RANDOM"}},
+	}
+	if _, err := updateRouters(cfg, current); err == nil {
+		t.Fatal("expected the invalid pool to be rejected")
+	}
+	if health := current[conf.DefaultPoolKey].Health(); len(health) != 1 || !health["http://svc-a:8080"] {
+		t.Fatalf("expected the DEFAULT router to keep its services, got %v", health)
+	}
+}
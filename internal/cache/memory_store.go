@@ -0,0 +1,388 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMemoryMaxEntries       = 10_000
+	DefaultMemoryMaxBytes   int64 = 256 << 20
+
+	doneMarkerSweepThreshold = 1024
+)
+
+// MemoryStore is an in-process Store for single-node deployments. Responses are
+// bounded by entry count and byte budget; expired entries are evicted first,
+// then the least recently used. Leader locks and done notifications only
+// coordinate goroutines of this process.
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	entries    map[string]*list.Element
+	lru        *list.List
+	expiry     expiryHeap
+	locks      map[string]memoryLock
+	done       map[string]time.Time
+	waiters    map[string][]chan struct{}
+	now        func() time.Time
+}
+
+type memoryEntry struct {
+	key       string
+	entry     *Entry
+	size      int64
+	expiresAt time.Time
+	// index is the entry's position in MemoryStore.expiry.
+	index int
+}
+
+// expiryHeap orders stored entries by expiry, soonest first.
+type expiryHeap []*memoryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	stored := x.(*memoryEntry)
+	stored.index = len(*h)
+	*h = append(*h, stored)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	stored := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return stored
+}
+
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+func NewMemoryStore(maxEntries int, maxBytes int64) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMemoryMaxEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMemoryMaxBytes
+	}
+
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		locks:      make(map[string]memoryLock),
+		done:       make(map[string]time.Time),
+		waiters:    make(map[string][]chan struct{}),
+		now:        time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+
+	stored := element.Value.(*memoryEntry)
+	if !s.now().Before(stored.expiresAt) {
+		s.removeElement(element)
+		return nil, nil
+	}
+
+	s.lru.MoveToFront(element)
+	return stored.entry.Clone(), nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %s", ttl)
+	}
+
+	size := entrySize(key, entry)
+	if size > s.maxBytes {
+		return fmt.Errorf("response of %d bytes exceeds memory cache budget of %d bytes", size, s.maxBytes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.removeElement(element)
+	}
+
+	stored := &memoryEntry{
+		key:       key,
+		entry:     entry.Clone(),
+		size:      size,
+		expiresAt: s.now().Add(ttl),
+	}
+	s.entries[key] = s.lru.PushFront(stored)
+	heap.Push(&s.expiry, stored)
+	s.bytes += size
+
+	s.evict()
+	return nil
+}
+
+func (s *MemoryStore) TryAcquireLeader(_ context.Context, key string, ttl time.Duration) (*Lock, bool, error) {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, false, fmt.Errorf("generate lock token: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if existing, ok := s.locks[key]; ok && now.Before(existing.expiresAt) {
+		return nil, false, nil
+	}
+
+	s.locks[key] = memoryLock{token: token, expiresAt: now.Add(ttl)}
+	return &Lock{Key: key, Token: token}, true, nil
+}
+
+func (s *MemoryStore) ReleaseLeader(_ context.Context, lock *Lock) error {
+	if lock == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.locks[lock.Key]; ok && existing.token == lock.Token {
+		delete(s.locks, lock.Key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) PublishDone(_ context.Context, key string) error {
+	s.mu.Lock()
+	now := s.now()
+	s.done[key] = now.Add(doneKeyTTL)
+	if len(s.done) > doneMarkerSweepThreshold {
+		s.sweepMarkers(now)
+	}
+	waiters := s.waiters[key]
+	delete(s.waiters, key)
+	s.mu.Unlock()
+
+	for _, waiter := range waiters {
+		close(waiter)
+	}
+	return nil
+}
+
+func (s *MemoryStore) WaitForDone(ctx context.Context, key string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultLockTTL
+	}
+
+	s.mu.Lock()
+	if expiresAt, ok := s.done[key]; ok && s.now().Before(expiresAt) {
+		s.mu.Unlock()
+		return nil
+	}
+	waiter := make(chan struct{})
+	s.waiters[key] = append(s.waiters[key], waiter)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter:
+		return nil
+	case <-timer.C:
+		s.removeWaiter(key, waiter)
+		return ErrWaitTimeout
+	case <-ctx.Done():
+		s.removeWaiter(key, waiter)
+		return ctx.Err()
+	}
+}
+
+// evictKey drops a single entry, reporting whether it was present.
+func (s *MemoryStore) evictKey(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	s.removeElement(element)
+	return true
+}
+
+func (s *MemoryStore) PurgeKey(_ context.Context, key string) (int, error) {
+	return len(s.purgeKey(key)), nil
+}
+
+func (s *MemoryStore) PurgePrefix(_ context.Context, host, pathPrefix string) (int, error) {
+	return len(s.purgePrefix(host, pathPrefix)), nil
+}
+
+func (s *MemoryStore) PurgeAll(context.Context) (int, error) {
+	return len(s.purgeAll()), nil
+}
+
+func (s *MemoryStore) PurgeTag(_ context.Context, host, tag string) (int, error) {
+	return len(s.purgeTag(host, tag)), nil
+}
+
+func (s *MemoryStore) purgeTag(host, tag string) []string {
+	return s.removeMatching(func(_ string, entry *Entry) bool {
+		return (host == "" || entry.Host == host) && slices.Contains(entry.Tags, tag)
+	})
+}
+
+func (s *MemoryStore) purgeKey(key string) []string {
+	return s.removeMatching(func(candidate string, _ *Entry) bool {
+		return candidate == key || strings.HasPrefix(candidate, key+".")
+	})
+}
+
+func (s *MemoryStore) purgePrefix(host, pathPrefix string) []string {
+	return s.removeMatching(func(_ string, entry *Entry) bool {
+		return entry.Host == host && entry.Path != "" && strings.HasPrefix(entry.Path, pathPrefix)
+	})
+}
+
+func (s *MemoryStore) purgeAll() []string {
+	return s.removeMatching(func(string, *Entry) bool { return true })
+}
+
+// removeMatching drops every unexpired entry accepted by match and returns their keys.
+func (s *MemoryStore) removeMatching(match func(key string, entry *Entry) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var removed []string
+	for key, element := range s.entries {
+		stored := element.Value.(*memoryEntry)
+		if !match(key, stored.entry) {
+			continue
+		}
+		if now.Before(stored.expiresAt) {
+			removed = append(removed, key)
+		}
+		s.removeElement(element)
+	}
+	return removed
+}
+
+func (s *MemoryStore) Ping(context.Context) error {
+	return nil
+}
+
+// evict drops expired entries, then least-recently-used ones, until both
+// budgets are satisfied. Callers must hold s.mu.
+func (s *MemoryStore) evict() {
+	now := s.now()
+	for s.overBudget() && s.expiry.Len() > 0 && !now.Before(s.expiry[0].expiresAt) {
+		s.removeElement(s.entries[s.expiry[0].key])
+	}
+	for s.overBudget() {
+		oldest := s.lru.Back()
+		if oldest == nil {
+			return
+		}
+		s.removeElement(oldest)
+	}
+}
+
+func (s *MemoryStore) overBudget() bool {
+	return s.lru.Len() > s.maxEntries || s.bytes > s.maxBytes
+}
+
+func (s *MemoryStore) removeElement(element *list.Element) {
+	stored := element.Value.(*memoryEntry)
+	s.lru.Remove(element)
+	heap.Remove(&s.expiry, stored.index)
+	delete(s.entries, stored.key)
+	s.bytes -= stored.size
+}
+
+func (s *MemoryStore) removeWaiter(key string, waiter chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	waiters := s.waiters[key]
+	for i, candidate := range waiters {
+		if candidate == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(s.waiters, key)
+		return
+	}
+	s.waiters[key] = waiters
+}
+
+func (s *MemoryStore) sweepMarkers(now time.Time) {
+	for key, expiresAt := range s.done {
+		if !now.Before(expiresAt) {
+			delete(s.done, key)
+		}
+	}
+	for key, lock := range s.locks {
+		if !now.Before(lock.expiresAt) {
+			delete(s.locks, key)
+		}
+	}
+}
+
+func entrySize(key string, entry *Entry) int64 {
+	size := int64(len(key) + len(entry.Path) + len(entry.Host))
+	for _, name := range entry.Vary {
+		size += int64(len(name))
+	}
+	for _, tag := range entry.Tags {
+		size += int64(len(tag))
+	}
+	if entry.Response == nil {
+		return size
+	}
+
+	size += int64(len(entry.Response.Body))
+	for name, values := range entry.Response.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
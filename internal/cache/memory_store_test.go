@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+)
+
+func TestMemoryStoreSetGetAndExpire(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	response := &proxy.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       []byte("hello"),
+	}
+	if err := store.Set(ctx, "key", &Entry{Response: response}, time.Minute); err != nil {
+		t.Fatalf("set response: %v", err)
+	}
+
+	cached, err := store.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("get response: %v", err)
+	}
+	if cached == nil || string(cached.Response.Body) != "hello" {
+		t.Fatalf("unexpected cached response %+v", cached)
+	}
+
+	now = now.Add(time.Minute)
+	cached, err = store.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("get expired response: %v", err)
+	}
+	if cached != nil {
+		t.Fatal("expected response to expire")
+	}
+	if store.bytes != 0 {
+		t.Fatalf("expected expired entry to release its bytes, got %d", store.bytes)
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsedByCount(t *testing.T) {
+	store := NewMemoryStore(2, 0)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b"} {
+		if err := store.Set(ctx, key, &Entry{Response: &proxy.Response{StatusCode: http.StatusOK}}, time.Minute); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+
+	// Touch "a" so that "b" becomes the eviction candidate.
+	if cached, _ := store.Get(ctx, "a"); cached == nil {
+		t.Fatal("expected a to be cached")
+	}
+	if err := store.Set(ctx, "c", &Entry{Response: &proxy.Response{StatusCode: http.StatusOK}}, time.Minute); err != nil {
+		t.Fatalf("set c: %v", err)
+	}
+
+	if cached, _ := store.Get(ctx, "b"); cached != nil {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if cached, _ := store.Get(ctx, key); cached == nil {
+			t.Fatalf("expected %s to remain cached", key)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsExpiredEntriesFirst(t *testing.T) {
+	store := NewMemoryStore(2, 0)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := store.Set(ctx, "live", &Entry{Response: &proxy.Response{StatusCode: http.StatusOK}}, time.Hour); err != nil {
+		t.Fatalf("set live: %v", err)
+	}
+	if err := store.Set(ctx, "expiring", &Entry{Response: &proxy.Response{StatusCode: http.StatusOK}}, time.Minute); err != nil {
+		t.Fatalf("set expiring: %v", err)
+	}
+
+	// "live" is now the least recently used entry, but "expiring" has expired.
+	now = now.Add(2 * time.Minute)
+	if err := store.Set(ctx, "new", &Entry{Response: &proxy.Response{StatusCode: http.StatusOK}}, time.Hour); err != nil {
+		t.Fatalf("set new: %v", err)
+	}
+
+	if _, ok := store.entries["expiring"]; ok {
+		t.Fatal("expected the expired entry to be evicted")
+	}
+	for _, key := range []string{"live", "new"} {
+		if cached, _ := store.Get(ctx, key); cached == nil {
+			t.Fatalf("expected %s to remain cached", key)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsByByteBudget(t *testing.T) {
+	store := NewMemoryStore(0, 20)
+	ctx := context.Background()
+
+	if err := store.Set(ctx, "a", &Entry{Response: &proxy.Response{Body: []byte("0123456789")}}, time.Minute); err != nil {
+		t.Fatalf("set a: %v", err)
+	}
+	if err := store.Set(ctx, "b", &Entry{Response: &proxy.Response{Body: []byte("0123456789")}}, time.Minute); err != nil {
+		t.Fatalf("set b: %v", err)
+	}
+
+	if cached, _ := store.Get(ctx, "a"); cached != nil {
+		t.Fatal("expected a to be evicted once the byte budget is exceeded")
+	}
+	if store.bytes > store.maxBytes {
+		t.Fatalf("expected bytes within budget, got %d", store.bytes)
+	}
+
+	if err := store.Set(ctx, "huge", &Entry{Response: &proxy.Response{Body: make([]byte, 64)}}, time.Minute); err == nil {
+		t.Fatal("expected error for a response larger than the byte budget")
+	}
+}
+
+func TestMemoryStorePurge(t *testing.T) {
+	ctx := context.Background()
+	seed := func() *MemoryStore {
+		store := NewMemoryStore(0, 0)
+		entries := map[string]string{
+			"post":         "/blog/post",
+			"post.variant": "/blog/post",
+			"other":        "/blog/other",
+			"home":         "/",
+		}
+		for key, path := range entries {
+			entry := &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte(key)}, Path: path}
+			if err := store.Set(ctx, key, entry, time.Minute); err != nil {
+				t.Fatalf("set %s: %v", key, err)
+			}
+		}
+		return store
+	}
+
+	tests := []struct {
+		name      string
+		purge     func(*MemoryStore) (int, error)
+		wantCount int
+		wantLeft  []string
+	}{
+		{name: "key with variants", purge: func(s *MemoryStore) (int, error) { return s.PurgeKey(ctx, "post") }, wantCount: 2, wantLeft: []string{"other", "home"}},
+		{name: "path prefix", purge: func(s *MemoryStore) (int, error) { return s.PurgePrefix(ctx, "", "/blog/") }, wantCount: 3, wantLeft: []string{"home"}},
+		{name: "all", purge: func(s *MemoryStore) (int, error) { return s.PurgeAll(ctx) }, wantCount: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := seed()
+			removed, err := tt.purge(store)
+			if err != nil {
+				t.Fatalf("purge: %v", err)
+			}
+			if removed != tt.wantCount {
+				t.Fatalf("expected %d purged entries, got %d", tt.wantCount, removed)
+			}
+			if len(store.entries) != len(tt.wantLeft) {
+				t.Fatalf("expected %d entries left, got %d", len(tt.wantLeft), len(store.entries))
+			}
+			for _, key := range tt.wantLeft {
+				if cached, _ := store.Get(ctx, key); cached == nil {
+					t.Fatalf("expected %s to survive the purge", key)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreLeaderLockLifecycle(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+	ctx := context.Background()
+
+	lock1, acquired, err := store.TryAcquireLeader(ctx, "key", 5*time.Second)
+	if err != nil || !acquired {
+		t.Fatalf("expected first lock acquisition to succeed, acquired=%v err=%v", acquired, err)
+	}
+
+	if _, acquired, _ := store.TryAcquireLeader(ctx, "key", 5*time.Second); acquired {
+		t.Fatal("expected second lock acquisition to fail while lock is held")
+	}
+
+	if err := store.ReleaseLeader(ctx, &Lock{Key: "key", Token: "other"}); err != nil {
+		t.Fatalf("release foreign lock: %v", err)
+	}
+	if _, acquired, _ := store.TryAcquireLeader(ctx, "key", 5*time.Second); acquired {
+		t.Fatal("expected lock to survive release with a foreign token")
+	}
+
+	if err := store.ReleaseLeader(ctx, lock1); err != nil {
+		t.Fatalf("release leader lock: %v", err)
+	}
+	if _, acquired, _ := store.TryAcquireLeader(ctx, "key", 5*time.Second); !acquired {
+		t.Fatal("expected lock acquisition after release")
+	}
+}
+
+func TestMemoryStoreWaitForDoneNotification(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+	ctx := context.Background()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- store.WaitForDone(ctx, "key", 2*time.Second)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := store.PublishDone(ctx, "key"); err != nil {
+		t.Fatalf("publish done: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("wait for done returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait for done timed out")
+	}
+
+	// A late follower observes the done marker without blocking.
+	if err := store.WaitForDone(ctx, "key", time.Millisecond); err != nil {
+		t.Fatalf("expected recent done marker to satisfy wait, got %v", err)
+	}
+}
+
+func TestMemoryStoreWaitForDoneTimeout(t *testing.T) {
+	store := NewMemoryStore(0, 0)
+
+	err := store.WaitForDone(context.Background(), "key", 10*time.Millisecond)
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("expected ErrWaitTimeout, got %v", err)
+	}
+	if len(store.waiters) != 0 {
+		t.Fatalf("expected timed out waiter to be removed, got %d", len(store.waiters))
+	}
+}
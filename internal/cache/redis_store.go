@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -18,19 +20,52 @@ const (
 	lockPrefix     = "lock:"
 	donePrefix     = "done:"
 	doneKeyPrefix  = "done-key:"
+	tagPrefix      = "tag:"
+	// variantsPrefix keys the set of variant keys stored for each base key, so
+	// a URL purge finds them without scanning the keyspace.
+	variantsPrefix = "variants:"
+
+	// pathIndexKey is a sorted set of pathMember values with equal scores, so
+	// the entries under a path prefix are a lexical range of it. pathExpiryKey
+	// scores the same members by expiry, so the members of expired entries can
+	// be trimmed as new entries are written.
+	pathIndexKey  = "index:paths"
+	pathExpiryKey = "index:paths:expiry"
+
+	defaultLockTTL = 15 * time.Second
+	doneKeyTTL     = 5 * time.Second
+
+	purgeBatchSize = 500
+	// pathTrimBatch is how many expired path index members each write removes.
+	pathTrimBatch = 100
 )
 
 var ErrWaitTimeout = errors.New("wait timeout")
 
 type Store interface {
-	Get(ctx context.Context, key string) (*proxy.Response, error)
-	Set(ctx context.Context, key string, response *proxy.Response, ttl time.Duration) error
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
 	TryAcquireLeader(ctx context.Context, key string, ttl time.Duration) (*Lock, bool, error)
 	ReleaseLeader(ctx context.Context, lock *Lock) error
 	PublishDone(ctx context.Context, key string) error
 	WaitForDone(ctx context.Context, key string, timeout time.Duration) error
 }
 
+// Purger is implemented by stores that can evict cached responses on demand.
+// Each method reports how many entries were removed.
+type Purger interface {
+	// PurgeKey removes the entry stored under key together with its Vary variants.
+	PurgeKey(ctx context.Context, key string) (int, error)
+	// PurgePrefix removes every entry of host whose request path starts with
+	// pathPrefix.
+	PurgePrefix(ctx context.Context, host, pathPrefix string) (int, error)
+	// PurgeAll removes every cached response.
+	PurgeAll(ctx context.Context) (int, error)
+	// PurgeTag removes every entry stored with the given surrogate key, only
+	// for host unless it is empty.
+	PurgeTag(ctx context.Context, host, tag string) (int, error)
+}
+
 type RedisStore struct {
 	client *redis.Client
 }
@@ -40,10 +75,65 @@ type Lock struct {
 	Token string
 }
 
+// Entry is a cached response together with the end of its freshness lifetime.
+// Stores keep an entry for the ttl given to Set, which may extend past FreshUntil
+// so that a stale copy stays available.
+//
+// An entry without a Response is a variant manifest: it records the request
+// headers (Vary) that select between representations stored under variant keys.
+type Entry struct {
+	Response   *proxy.Response
+	FreshUntil time.Time
+	Vary       []string
+	// Path is the request path the entry was stored for, used by prefix purges.
+	Path string
+	// Host is the normalized host the entry was stored for when virtual hosts
+	// are configured, and empty otherwise.
+	Host string
+	// Tags are the surrogate keys the entry is indexed under for tag purges.
+	Tags []string
+}
+
+// IsFresh reports whether the entry is still within its freshness lifetime.
+// Entries written without a freshness lifetime are fresh for as long as they exist.
+func (e *Entry) IsFresh(now time.Time) bool {
+	return e.FreshUntil.IsZero() || now.Before(e.FreshUntil)
+}
+
+// IsVariantManifest reports whether the entry only lists Vary headers.
+func (e *Entry) IsVariantManifest() bool {
+	return e.Response == nil && len(e.Vary) > 0
+}
+
+func (e *Entry) Clone() *Entry {
+	clone := &Entry{
+		FreshUntil: e.FreshUntil,
+		Vary:       append([]string(nil), e.Vary...),
+		Path:       e.Path,
+		Host:       e.Host,
+		Tags:       append([]string(nil), e.Tags...),
+	}
+	if e.Response != nil {
+		clone.Response = e.Response.Clone()
+	}
+	return clone
+}
+
+func (e *Entry) validate() error {
+	if e == nil || (e.Response == nil && len(e.Vary) == 0) {
+		return errors.New("response cannot be nil")
+	}
+	return nil
+}
+
 type cachedResponse struct {
 	StatusCode int                 `json:"statusCode"`
 	Header     map[string][]string `json:"header"`
 	Body       []byte              `json:"body"`
+	FreshUntil time.Time           `json:"freshUntil,omitempty"`
+	Vary       []string            `json:"vary,omitempty"`
+	Path       string              `json:"path,omitempty"`
+	Host       string              `json:"host,omitempty"`
 }
 
 func NewRedisStore(redisURL string) (*RedisStore, error) {
@@ -63,7 +153,7 @@ func NewRedisStore(redisURL string) (*RedisStore, error) {
 	return &RedisStore{client: client}, nil
 }
 
-func (s *RedisStore) Get(ctx context.Context, key string) (*proxy.Response, error) {
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
 	value, err := s.client.Get(ctx, responsePrefix+key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -72,27 +162,66 @@ func (s *RedisStore) Get(ctx context.Context, key string) (*proxy.Response, erro
 		return nil, fmt.Errorf("get cached response: %w", err)
 	}
 
+	return decodeEntry(value)
+}
+
+// getWithTTL reads a cached entry together with its remaining time to live.
+func (s *RedisStore) getWithTTL(ctx context.Context, key string) (*Entry, time.Duration, error) {
+	var (
+		value *redis.StringCmd
+		ttl   *redis.DurationCmd
+	)
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.Get(ctx, responsePrefix+key)
+		ttl = pipe.PTTL(ctx, responsePrefix+key)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("get cached response: %w", err)
+	}
+
+	serialized, err := value.Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("get cached response: %w", err)
+	}
+
+	entry, err := decodeEntry(serialized)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry, ttl.Val(), nil
+}
+
+func decodeEntry(value string) (*Entry, error) {
 	var cached cachedResponse
 	if err := json.Unmarshal([]byte(value), &cached); err != nil {
 		return nil, fmt.Errorf("decode cached response: %w", err)
 	}
 
-	return &proxy.Response{
-		StatusCode: cached.StatusCode,
-		Header:     cached.Header,
-		Body:       append([]byte(nil), cached.Body...),
-	}, nil
+	entry := &Entry{FreshUntil: cached.FreshUntil, Vary: cached.Vary, Path: cached.Path, Host: cached.Host}
+	if cached.StatusCode != 0 {
+		entry.Response = &proxy.Response{
+			StatusCode: cached.StatusCode,
+			Header:     cached.Header,
+			Body:       append([]byte(nil), cached.Body...),
+		}
+	}
+	return entry, nil
 }
 
-func (s *RedisStore) Set(ctx context.Context, key string, response *proxy.Response, ttl time.Duration) error {
-	if response == nil {
-		return errors.New("response cannot be nil")
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if err := entry.validate(); err != nil {
+		return err
 	}
 
-	cached := cachedResponse{
-		StatusCode: response.StatusCode,
-		Header:     response.Header,
-		Body:       response.Body,
+	cached := cachedResponse{FreshUntil: entry.FreshUntil, Vary: entry.Vary, Path: entry.Path, Host: entry.Host}
+	if entry.Response != nil {
+		cached.StatusCode = entry.Response.StatusCode
+		cached.Header = entry.Response.Header
+		cached.Body = entry.Response.Body
 	}
 
 	serialized, err := json.Marshal(cached)
@@ -100,16 +229,109 @@ func (s *RedisStore) Set(ctx context.Context, key string, response *proxy.Respon
 		return fmt.Errorf("encode cached response: %w", err)
 	}
 
-	if err := s.client.Set(ctx, responsePrefix+key, serialized, ttl).Err(); err != nil {
+	if err := s.store(ctx, key, serialized, entry.Host, entry.Path, ttl); err != nil {
 		return fmt.Errorf("set cached response: %w", err)
 	}
 
+	return s.indexTags(ctx, indexMember(entry.Host, key), entry.Tags, ttl)
+}
+
+// store writes a serialized entry and indexes its host, path and base key in one
+// script, so every entry a prefix or URL purge should find is indexed. Like a
+// tag's set, a base key's variant set lives as long as its longest-lived member.
+func (s *RedisStore) store(ctx context.Context, key string, serialized []byte, host, path string, ttl time.Duration) error {
+	const script = `
+if tonumber(ARGV[2]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+if KEYS[4] ~= "" then
+	redis.call("SADD", KEYS[4], ARGV[7])
+	local remaining = redis.call("PTTL", KEYS[4])
+	if tonumber(ARGV[2]) > 0 and remaining < tonumber(ARGV[2]) then
+		redis.call("PEXPIRE", KEYS[4], ARGV[2])
+	end
+end
+if ARGV[3] == "" then
+	return 0
+end
+redis.call("ZADD", KEYS[2], 0, ARGV[3])
+redis.call("ZADD", KEYS[3], ARGV[4], ARGV[3])
+local expired = redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", ARGV[5], "LIMIT", 0, ARGV[6])
+if #expired > 0 then
+	redis.call("ZREM", KEYS[2], unpack(expired))
+	redis.call("ZREM", KEYS[3], unpack(expired))
+end
+return #expired
+`
+	now := time.Now()
+	member, expiresAt, ttlMillis := "", "+inf", int64(0)
+	if path != "" {
+		member = indexMember(host+"\x00"+path, key)
+	}
+	if ttl > 0 {
+		ttlMillis = max(ttl.Milliseconds(), 1)
+		expiresAt = strconv.FormatInt(now.UnixMilli()+ttlMillis, 10)
+	}
+	variants := ""
+	if base, _, isVariant := strings.Cut(key, "."); isVariant {
+		variants = variantsPrefix + base
+	}
+	keys := []string{responsePrefix + key, pathIndexKey, pathExpiryKey, variants}
+	return s.client.Eval(ctx, script, keys, serialized, ttlMillis, member, expiresAt, now.UnixMilli(), pathTrimBatch, key).Err()
+}
+
+// indexMember is the member of an index set or sorted set standing for the
+// entry stored under key: the key, preceded by the host and path it is found
+// under and a NUL byte. Cache keys never contain one, so the key follows the
+// last NUL. Tag index members of entries without a host are just the key.
+func indexMember(scope, key string) string {
+	if scope == "" {
+		return key
+	}
+	return scope + "\x00" + key
+}
+
+// splitIndexMember returns the scope and cache key of an index member.
+func splitIndexMember(member string) (scope, key string) {
+	separator := strings.LastIndexByte(member, 0)
+	if separator < 0 {
+		return "", member
+	}
+	return member[:separator], member[separator+1:]
+}
+
+// indexTags adds key to the index set of each tag. A tag's set lives as long as
+// the longest-lived entry added to it; stale members are skipped when purging.
+func (s *RedisStore) indexTags(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	const script = `
+redis.call("SADD", KEYS[1], ARGV[1])
+local remaining = redis.call("PTTL", KEYS[1])
+if remaining >= 0 and remaining >= tonumber(ARGV[2]) then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+`
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			pipe.Eval(ctx, script, []string{tagPrefix + tag}, key, ttl.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("index cache tags: %w", err)
+	}
 	return nil
 }
 
 func (s *RedisStore) TryAcquireLeader(ctx context.Context, key string, ttl time.Duration) (*Lock, bool, error) {
 	if ttl <= 0 {
-		ttl = 15 * time.Second
+		ttl = defaultLockTTL
 	}
 
 	token, err := randomToken()
@@ -148,7 +370,7 @@ return 0
 }
 
 func (s *RedisStore) PublishDone(ctx context.Context, key string) error {
-	if err := s.client.Set(ctx, doneKeyPrefix+key, "1", 5*time.Second).Err(); err != nil {
+	if err := s.client.Set(ctx, doneKeyPrefix+key, "1", doneKeyTTL).Err(); err != nil {
 		return fmt.Errorf("set done key: %w", err)
 	}
 	if err := s.client.Publish(ctx, donePrefix+key, "done").Err(); err != nil {
@@ -159,7 +381,7 @@ func (s *RedisStore) PublishDone(ctx context.Context, key string) error {
 
 func (s *RedisStore) WaitForDone(ctx context.Context, key string, timeout time.Duration) error {
 	if timeout <= 0 {
-		timeout = 15 * time.Second
+		timeout = defaultLockTTL
 	}
 
 	doneKey := doneKeyPrefix + key
@@ -200,6 +422,174 @@ func (s *RedisStore) WaitForDone(ctx context.Context, key string, timeout time.D
 	}
 }
 
+func (s *RedisStore) PurgeKey(ctx context.Context, key string) (int, error) {
+	removed, err := s.purgeKey(ctx, key)
+	return len(removed), err
+}
+
+func (s *RedisStore) PurgePrefix(ctx context.Context, host, pathPrefix string) (int, error) {
+	removed, err := s.purgePrefix(ctx, host, pathPrefix)
+	return len(removed), err
+}
+
+func (s *RedisStore) PurgeAll(ctx context.Context) (int, error) {
+	removed, err := s.purgeAll(ctx)
+	return len(removed), err
+}
+
+func (s *RedisStore) PurgeTag(ctx context.Context, host, tag string) (int, error) {
+	removed, err := s.purgeTag(ctx, host, tag)
+	return len(removed), err
+}
+
+// purgeKey deletes key and the variant keys indexed under it, returning the keys
+// that existed.
+func (s *RedisStore) purgeKey(ctx context.Context, key string) ([]string, error) {
+	indexed, err := s.client.SMembers(ctx, variantsPrefix+key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read variant index: %w", err)
+	}
+
+	keys := []string{key}
+	members := make([]interface{}, len(indexed))
+	for i, member := range indexed {
+		keys = append(keys, member)
+		members[i] = member
+	}
+	removed, err := s.deleteKeys(ctx, keys)
+	if err != nil || len(members) == 0 {
+		return removed, err
+	}
+
+	// Only the members read above are removed so variants stored concurrently survive.
+	if err := s.client.SRem(ctx, variantsPrefix+key, members...).Err(); err != nil {
+		return removed, fmt.Errorf("update variant index: %w", err)
+	}
+	return removed, nil
+}
+
+// purgePrefix deletes every entry of host whose stored path starts with
+// pathPrefix, reading their keys from the path index a batch at a time.
+func (s *RedisStore) purgePrefix(ctx context.Context, host, pathPrefix string) ([]string, error) {
+	prefix := host + "\x00" + pathPrefix
+	bounds := &redis.ZRangeBy{Min: "[" + prefix, Max: "[" + prefix + "\xff", Count: purgeBatchSize}
+	var removed []string
+	for {
+		members, err := s.client.ZRangeByLex(ctx, pathIndexKey, bounds).Result()
+		if err != nil {
+			return removed, fmt.Errorf("read path index: %w", err)
+		}
+		if len(members) == 0 {
+			return removed, nil
+		}
+
+		keys := make([]string, len(members))
+		indexed := make([]interface{}, len(members))
+		for i, member := range members {
+			_, keys[i] = splitIndexMember(member)
+			indexed[i] = member
+		}
+		deleted, err := s.deleteKeys(ctx, keys)
+		removed = append(removed, deleted...)
+		if err != nil {
+			return removed, err
+		}
+		if err := s.client.ZRem(ctx, pathIndexKey, indexed...).Err(); err != nil {
+			return removed, fmt.Errorf("update path index: %w", err)
+		}
+		if err := s.client.ZRem(ctx, pathExpiryKey, indexed...).Err(); err != nil {
+			return removed, fmt.Errorf("update path index: %w", err)
+		}
+	}
+}
+
+func (s *RedisStore) purgeAll(ctx context.Context) ([]string, error) {
+	keys, err := s.scanKeys(ctx, responsePrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	removed, err := s.deleteKeys(ctx, keys)
+	if err != nil {
+		return removed, err
+	}
+	if err := s.client.Unlink(ctx, pathIndexKey, pathExpiryKey).Err(); err != nil {
+		return removed, fmt.Errorf("delete path index: %w", err)
+	}
+	return removed, nil
+}
+
+// purgeTag deletes every entry indexed under tag and drops them from the index.
+func (s *RedisStore) purgeTag(ctx context.Context, host, tag string) ([]string, error) {
+	indexed, err := s.client.SMembers(ctx, tagPrefix+tag).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read tag index: %w", err)
+	}
+
+	var (
+		keys    []string
+		members []interface{}
+	)
+	for _, member := range indexed {
+		memberHost, key := splitIndexMember(member)
+		if host != "" && memberHost != host {
+			continue
+		}
+		keys = append(keys, key)
+		members = append(members, member)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	removed, err := s.deleteKeys(ctx, keys)
+	if err != nil {
+		return removed, err
+	}
+
+	// Only the members read above are removed so entries indexed concurrently survive.
+	if err := s.client.SRem(ctx, tagPrefix+tag, members...).Err(); err != nil {
+		return removed, fmt.Errorf("update tag index: %w", err)
+	}
+	return removed, nil
+}
+
+// scanKeys lists cache keys (without responsePrefix) matching a Redis glob pattern.
+func (s *RedisStore) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iterator := s.client.Scan(ctx, 0, pattern, purgeBatchSize).Iterator()
+	for iterator.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iterator.Val(), responsePrefix))
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, fmt.Errorf("scan cached responses: %w", err)
+	}
+	return keys, nil
+}
+
+// deleteKeys removes the given cache keys and returns the ones that existed.
+func (s *RedisStore) deleteKeys(ctx context.Context, keys []string) ([]string, error) {
+	var removed []string
+	for start := 0; start < len(keys); start += purgeBatchSize {
+		batch := keys[start:min(start+purgeBatchSize, len(keys))]
+		results := make([]*redis.IntCmd, len(batch))
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range batch {
+				results[i] = pipe.Unlink(ctx, responsePrefix+key)
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, fmt.Errorf("delete cached responses: %w", err)
+		}
+		for i, key := range batch {
+			if results[i].Val() > 0 {
+				removed = append(removed, key)
+			}
+		}
+	}
+	return removed, nil
+}
+
 func randomToken() (string, error) {
 	bytes := make([]byte, 16)
 	if _, err := rand.Read(bytes); err != nil {
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/robertomachorro/doormanlb/internal/proxy"
 )
 
@@ -22,7 +23,7 @@ func TestRedisStoreSetGetAndExpire(t *testing.T) {
 		Body:       []byte("hello"),
 	}
 
-	if err := store.Set(ctx, key, response, 120*time.Millisecond); err != nil {
+	if err := store.Set(ctx, key, &Entry{Response: response}, 120*time.Millisecond); err != nil {
 		t.Fatalf("set response: %v", err)
 	}
 
@@ -33,8 +34,8 @@ func TestRedisStoreSetGetAndExpire(t *testing.T) {
 	if cached == nil {
 		t.Fatal("expected cached response")
 	}
-	if string(cached.Body) != "hello" {
-		t.Fatalf("unexpected cached body %q", string(cached.Body))
+	if string(cached.Response.Body) != "hello" {
+		t.Fatalf("unexpected cached body %q", string(cached.Response.Body))
 	}
 
 	time.Sleep(180 * time.Millisecond)
@@ -47,6 +48,81 @@ func TestRedisStoreSetGetAndExpire(t *testing.T) {
 	}
 }
 
+func TestRedisStorePurgeKeyAndPrefix(t *testing.T) {
+	store := newIntegrationStore(t)
+	ctx := context.Background()
+	key := uniqueKey("purge")
+	path := "/" + key + "/"
+
+	for _, k := range []string{key, key + ".variant", key + "-other"} {
+		entry := &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte(k)}, Path: path + k}
+		if err := store.Set(ctx, k, entry, time.Minute); err != nil {
+			t.Fatalf("set %s: %v", k, err)
+		}
+	}
+	sibling := &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("sibling")}, Path: "/" + key + "-sibling/page"}
+	if err := store.Set(ctx, key+"-sibling", sibling, time.Minute); err != nil {
+		t.Fatalf("set sibling: %v", err)
+	}
+
+	removed, err := store.PurgeKey(ctx, key)
+	if err != nil {
+		t.Fatalf("purge key: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected key and variant to be purged, got %d", removed)
+	}
+	if members, err := store.client.SMembers(ctx, variantsPrefix+key).Result(); err != nil || len(members) != 0 {
+		t.Fatalf("expected purged variants to leave the variant index, got %v (%v)", members, err)
+	}
+
+	removed, err = store.PurgePrefix(ctx, "", path)
+	if err != nil {
+		t.Fatalf("purge prefix: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected remaining entry under prefix to be purged, got %d", removed)
+	}
+	if cached, _ := store.Get(ctx, key+"-other"); cached != nil {
+		t.Fatal("expected entry to be gone after prefix purge")
+	}
+	if cached, _ := store.Get(ctx, key+"-sibling"); cached == nil {
+		t.Fatal("expected entry outside the prefix to survive")
+	}
+	if members, err := store.client.ZRangeByLex(ctx, pathIndexKey, &redis.ZRangeBy{Min: "[" + path, Max: "[" + path + "\xff"}).Result(); err != nil || len(members) != 0 {
+		t.Fatalf("expected purged entries to leave the path index, got %v (%v)", members, err)
+	}
+}
+
+func TestRedisStorePurgeTag(t *testing.T) {
+	store := newIntegrationStore(t)
+	ctx := context.Background()
+	key := uniqueKey("tag")
+	tag := key + "-post"
+
+	for _, k := range []string{key + "-a", key + "-b"} {
+		entry := &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte(k)}, Tags: []string{tag}}
+		if err := store.Set(ctx, k, entry, time.Minute); err != nil {
+			t.Fatalf("set %s: %v", k, err)
+		}
+	}
+	untagged := &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("c")}}
+	if err := store.Set(ctx, key+"-c", untagged, time.Minute); err != nil {
+		t.Fatalf("set untagged: %v", err)
+	}
+
+	removed, err := store.PurgeTag(ctx, "", tag)
+	if err != nil {
+		t.Fatalf("purge tag: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected two tagged entries to be purged, got %d", removed)
+	}
+	if cached, _ := store.Get(ctx, key+"-c"); cached == nil {
+		t.Fatal("expected untagged entry to survive")
+	}
+}
+
 func TestRedisStoreLeaderLockLifecycle(t *testing.T) {
 	store := newIntegrationStore(t)
 	ctx := context.Background()
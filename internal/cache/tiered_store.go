@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultL1TTL = 10 * time.Second
+
+	invalidateChannel = "invalidate"
+	// invalidateAll is published instead of a key when every entry was purged.
+	invalidateAll = "*"
+)
+
+// TieredStore keeps a small per-process MemoryStore (L1) in front of a shared
+// RedisStore (L2). Writes go to both tiers and are broadcast over Redis pub/sub so
+// that other replicas drop their L1 copy; L1 entries additionally never outlive
+// the L1 TTL, which bounds staleness if an invalidation message is lost.
+// Leader locks and done notifications are always coordinated through L2.
+type TieredStore struct {
+	l1       *MemoryStore
+	l2       *RedisStore
+	l1TTL    time.Duration
+	originID string
+	pubsub   *redis.PubSub
+	stopped  chan struct{}
+
+	l1Hits          atomic.Uint64
+	l1Misses        atomic.Uint64
+	l1Invalidations atomic.Uint64
+}
+
+func NewTieredStore(l1 *MemoryStore, l2 *RedisStore, l1TTL time.Duration) (*TieredStore, error) {
+	if l1TTL <= 0 {
+		l1TTL = DefaultL1TTL
+	}
+
+	originID, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate origin id: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pubsub := l2.client.Subscribe(ctx, invalidateChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("subscribe invalidation channel: %w", err)
+	}
+
+	store := &TieredStore{
+		l1:       l1,
+		l2:       l2,
+		l1TTL:    l1TTL,
+		originID: originID,
+		pubsub:   pubsub,
+		stopped:  make(chan struct{}),
+	}
+	go store.listenForInvalidations()
+
+	return store, nil
+}
+
+func (s *TieredStore) Get(ctx context.Context, key string) (*Entry, error) {
+	if entry, _ := s.l1.Get(ctx, key); entry != nil {
+		s.l1Hits.Add(1)
+		return entry, nil
+	}
+	s.l1Misses.Add(1)
+
+	entry, remaining, err := s.l2.getWithTTL(ctx, key)
+	if err != nil || entry == nil {
+		return entry, err
+	}
+
+	// Oversized responses are simply served from L2 every time.
+	_ = s.l1.Set(ctx, key, entry, s.boundedL1TTL(remaining))
+	return entry, nil
+}
+
+func (s *TieredStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if err := s.l2.Set(ctx, key, entry, ttl); err != nil {
+		return err
+	}
+
+	_ = s.l1.Set(ctx, key, entry, s.boundedL1TTL(ttl))
+	return s.publishInvalidation(ctx, key)
+}
+
+func (s *TieredStore) TryAcquireLeader(ctx context.Context, key string, ttl time.Duration) (*Lock, bool, error) {
+	return s.l2.TryAcquireLeader(ctx, key, ttl)
+}
+
+func (s *TieredStore) ReleaseLeader(ctx context.Context, lock *Lock) error {
+	return s.l2.ReleaseLeader(ctx, lock)
+}
+
+func (s *TieredStore) PublishDone(ctx context.Context, key string) error {
+	return s.l2.PublishDone(ctx, key)
+}
+
+func (s *TieredStore) WaitForDone(ctx context.Context, key string, timeout time.Duration) error {
+	return s.l2.WaitForDone(ctx, key, timeout)
+}
+
+func (s *TieredStore) Ping(ctx context.Context) error {
+	return s.l2.Ping(ctx)
+}
+
+// PurgeKey, PurgePrefix, PurgeTag and PurgeAll delete matching entries from Redis and this
+// process's L1, then broadcast the removed keys so other replicas drop theirs.
+func (s *TieredStore) PurgeKey(ctx context.Context, key string) (int, error) {
+	removed, err := s.l2.purgeKey(ctx, key)
+	s.l1.purgeKey(key)
+	return s.broadcastPurge(ctx, removed, err)
+}
+
+func (s *TieredStore) PurgePrefix(ctx context.Context, host, pathPrefix string) (int, error) {
+	removed, err := s.l2.purgePrefix(ctx, host, pathPrefix)
+	s.l1.purgePrefix(host, pathPrefix)
+	return s.broadcastPurge(ctx, removed, err)
+}
+
+func (s *TieredStore) PurgeTag(ctx context.Context, host, tag string) (int, error) {
+	removed, err := s.l2.purgeTag(ctx, host, tag)
+	for _, key := range removed {
+		s.l1.evictKey(key)
+	}
+	return s.broadcastPurge(ctx, removed, err)
+}
+
+func (s *TieredStore) PurgeAll(ctx context.Context) (int, error) {
+	removed, err := s.l2.purgeAll(ctx)
+	s.l1.purgeAll()
+	if err != nil {
+		return len(removed), err
+	}
+	return len(removed), s.publishInvalidation(ctx, invalidateAll)
+}
+
+func (s *TieredStore) broadcastPurge(ctx context.Context, removed []string, purgeErr error) (int, error) {
+	for _, key := range removed {
+		if err := s.publishInvalidation(ctx, key); err != nil {
+			return len(removed), err
+		}
+	}
+	return len(removed), purgeErr
+}
+
+func (s *TieredStore) Metrics() map[string]uint64 {
+	return map[string]uint64{
+		"cache_l1_hits_total":          s.l1Hits.Load(),
+		"cache_l1_misses_total":        s.l1Misses.Load(),
+		"cache_l1_invalidations_total": s.l1Invalidations.Load(),
+	}
+}
+
+// Close stops listening for invalidations from other replicas.
+func (s *TieredStore) Close() error {
+	err := s.pubsub.Close()
+	<-s.stopped
+	return err
+}
+
+func (s *TieredStore) boundedL1TTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > s.l1TTL {
+		return s.l1TTL
+	}
+	return ttl
+}
+
+func (s *TieredStore) publishInvalidation(ctx context.Context, key string) error {
+	if err := s.l2.client.Publish(ctx, invalidateChannel, s.originID+" "+key).Err(); err != nil {
+		return fmt.Errorf("publish invalidation: %w", err)
+	}
+	return nil
+}
+
+func (s *TieredStore) listenForInvalidations() {
+	defer close(s.stopped)
+
+	for message := range s.pubsub.Channel() {
+		origin, key, ok := strings.Cut(message.Payload, " ")
+		if !ok {
+			log.Printf("ignoring malformed cache invalidation %q", message.Payload)
+			continue
+		}
+		if origin == s.originID {
+			continue
+		}
+		if key == invalidateAll {
+			s.l1Invalidations.Add(uint64(len(s.l1.purgeAll())))
+			continue
+		}
+		if s.l1.evictKey(key) {
+			s.l1Invalidations.Add(1)
+		}
+	}
+}
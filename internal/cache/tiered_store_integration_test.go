@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+)
+
+func TestTieredStoreFillsL1AndInvalidatesAcrossReplicas(t *testing.T) {
+	replicaA := newTieredIntegrationStore(t)
+	replicaB := newTieredIntegrationStore(t)
+	ctx := context.Background()
+	key := uniqueKey("tiered")
+
+	if err := replicaA.Set(ctx, key, &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("v1")}}, time.Minute); err != nil {
+		t.Fatalf("set v1: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		cached, err := replicaB.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("get from replica B: %v", err)
+		}
+		if cached == nil || string(cached.Response.Body) != "v1" {
+			t.Fatalf("expected v1 from replica B, got %+v", cached)
+		}
+	}
+	if metrics := replicaB.Metrics(); metrics["cache_l1_hits_total"] != 1 || metrics["cache_l1_misses_total"] != 1 {
+		t.Fatalf("expected one L1 miss then one L1 hit, got %v", metrics)
+	}
+
+	if err := replicaA.Set(ctx, key, &Entry{Response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("v2")}}, time.Minute); err != nil {
+		t.Fatalf("set v2: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cached, err := replicaB.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("get from replica B: %v", err)
+		}
+		if cached != nil && string(cached.Response.Body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected replica B to observe v2 after invalidation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTieredIntegrationStore(t *testing.T) *TieredStore {
+	t.Helper()
+	store, err := NewTieredStore(NewMemoryStore(0, 0), newIntegrationStore(t), time.Minute)
+	if err != nil {
+		t.Fatalf("new tiered store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
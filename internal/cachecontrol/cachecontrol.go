@@ -0,0 +1,166 @@
+package cachecontrol
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the shared-cache decision derived from upstream response headers.
+type Policy struct {
+	// NoStore is set when the response must not be kept by a shared cache.
+	NoStore bool
+	// HasLifetime reports whether the headers carried an explicit freshness lifetime.
+	HasLifetime bool
+	// Lifetime is the remaining freshness lifetime; zero or negative means already stale.
+	Lifetime time.Duration
+}
+
+// Parse evaluates Surrogate-Control, Cache-Control and Expires the way a shared
+// cache (CDN) does: Surrogate-Control wins over Cache-Control, s-maxage wins over
+// max-age, and Expires is only consulted when no max-age directive is present.
+func Parse(header http.Header, now time.Time) Policy {
+	surrogate := directives(header.Values("Surrogate-Control"))
+	if _, ok := surrogate["no-store"]; ok {
+		return Policy{NoStore: true}
+	}
+
+	control := directives(header.Values("Cache-Control"))
+	for _, directive := range []string{"no-store", "private"} {
+		if _, ok := control[directive]; ok {
+			return Policy{NoStore: true}
+		}
+	}
+
+	age := ageOf(header)
+	if lifetime, ok := seconds(surrogate, "max-age"); ok {
+		return Policy{HasLifetime: true, Lifetime: lifetime - age}
+	}
+	if _, ok := control["no-cache"]; ok {
+		// Every reuse would need revalidation, which doormanlb does not perform.
+		return Policy{HasLifetime: true}
+	}
+	if lifetime, ok := seconds(control, "s-maxage"); ok {
+		return Policy{HasLifetime: true, Lifetime: lifetime - age}
+	}
+	if lifetime, ok := seconds(control, "max-age"); ok {
+		return Policy{HasLifetime: true, Lifetime: lifetime - age}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates (such as "0") mean the response is already expired.
+			return Policy{HasLifetime: true}
+		}
+		date := now
+		if parsed, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = parsed
+		}
+		return Policy{HasLifetime: true, Lifetime: expiresAt.Sub(date) - age}
+	}
+
+	return Policy{}
+}
+
+// Shareable reports whether a response may be handed to clients other than the
+// one that asked for it, even without being stored. Responses that are private,
+// must not be stored or reused without revalidation, or set cookies are not.
+func Shareable(header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	control := directives(header.Values("Cache-Control"))
+	for _, directive := range []string{"private", "no-store", "no-cache"} {
+		if _, ok := control[directive]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// VaryHeaders returns the canonical, sorted header names listed in Vary.
+// wildcard is set for "Vary: *", which no cache can satisfy.
+func VaryHeaders(header http.Header) (names []string, wildcard bool) {
+	seen := make(map[string]bool)
+	for _, value := range header.Values("Vary") {
+		for _, part := range strings.Split(value, ",") {
+			name := strings.TrimSpace(part)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, true
+			}
+			name = http.CanonicalHeaderKey(name)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, false
+}
+
+// SurrogateKeys returns the deduplicated cache tags a response is labelled with,
+// read from Surrogate-Key (space separated) and Cache-Tag (comma separated).
+func SurrogateKeys(header http.Header) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, value := range header.Values("Surrogate-Key") {
+		for _, tag := range strings.Fields(value) {
+			add(tag)
+		}
+	}
+	for _, value := range header.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			add(tag)
+		}
+	}
+	return tags
+}
+
+func directives(values []string) map[string]string {
+	parsed := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			parsed[name] = strings.Trim(strings.TrimSpace(argument), `"`)
+		}
+	}
+	return parsed
+}
+
+func seconds(parsed map[string]string, directive string) (time.Duration, bool) {
+	argument, ok := parsed[directive]
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(argument, 10, 64)
+	if err != nil || value < 0 {
+		return 0, true
+	}
+	return time.Duration(value) * time.Second, true
+}
+
+func ageOf(header http.Header) time.Duration {
+	value, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return time.Duration(value) * time.Second
+}
@@ -0,0 +1,158 @@
+package cachecontrol
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   Policy
+	}{
+		{
+			name:   "no directives",
+			header: http.Header{},
+			want:   Policy{},
+		},
+		{
+			name:   "no-store",
+			header: http.Header{"Cache-Control": []string{"public, no-store"}},
+			want:   Policy{NoStore: true},
+		},
+		{
+			name:   "private",
+			header: http.Header{"Cache-Control": []string{"private, max-age=60"}},
+			want:   Policy{NoStore: true},
+		},
+		{
+			name:   "max-age",
+			header: http.Header{"Cache-Control": []string{"public, max-age=5"}},
+			want:   Policy{HasLifetime: true, Lifetime: 5 * time.Second},
+		},
+		{
+			name:   "s-maxage wins over max-age",
+			header: http.Header{"Cache-Control": []string{"max-age=5, s-maxage=300"}},
+			want:   Policy{HasLifetime: true, Lifetime: 300 * time.Second},
+		},
+		{
+			name: "surrogate-control wins over cache-control",
+			header: http.Header{
+				"Surrogate-Control": []string{"max-age=3600"},
+				"Cache-Control":     []string{"max-age=0"},
+			},
+			want: Policy{HasLifetime: true, Lifetime: time.Hour},
+		},
+		{
+			name: "surrogate-control no-store",
+			header: http.Header{
+				"Surrogate-Control": []string{"no-store"},
+				"Cache-Control":     []string{"max-age=60"},
+			},
+			want: Policy{NoStore: true},
+		},
+		{
+			name:   "no-cache is immediately stale",
+			header: http.Header{"Cache-Control": []string{"no-cache"}},
+			want:   Policy{HasLifetime: true},
+		},
+		{
+			name:   "age is subtracted",
+			header: http.Header{"Cache-Control": []string{"max-age=60"}, "Age": []string{"20"}},
+			want:   Policy{HasLifetime: true, Lifetime: 40 * time.Second},
+		},
+		{
+			name: "expires relative to date",
+			header: http.Header{
+				"Date":    []string{now.Add(-time.Minute).Format(http.TimeFormat)},
+				"Expires": []string{now.Add(time.Minute).Format(http.TimeFormat)},
+			},
+			want: Policy{HasLifetime: true, Lifetime: 2 * time.Minute},
+		},
+		{
+			name:   "expires without date uses now",
+			header: http.Header{"Expires": []string{now.Add(90 * time.Second).Format(http.TimeFormat)}},
+			want:   Policy{HasLifetime: true, Lifetime: 90 * time.Second},
+		},
+		{
+			name:   "invalid expires is stale",
+			header: http.Header{"Expires": []string{"0"}},
+			want:   Policy{HasLifetime: true},
+		},
+		{
+			name: "max-age wins over expires",
+			header: http.Header{
+				"Cache-Control": []string{"max-age=10"},
+				"Expires":       []string{now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			want: Policy{HasLifetime: true, Lifetime: 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.header, now)
+			if got != tt.want {
+				t.Fatalf("Parse()=%+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaryHeaders(t *testing.T) {
+	header := http.Header{"Vary": []string{"accept-language, Accept-Encoding", "Accept-Encoding"}}
+
+	names, wildcard := VaryHeaders(header)
+	if wildcard {
+		t.Fatal("expected no wildcard")
+	}
+	if len(names) != 2 || names[0] != "Accept-Encoding" || names[1] != "Accept-Language" {
+		t.Fatalf("unexpected vary headers %v", names)
+	}
+
+	if _, wildcard := VaryHeaders(http.Header{"Vary": []string{"Accept-Encoding, *"}}); !wildcard {
+		t.Fatal("expected wildcard for Vary: *")
+	}
+}
+
+func TestSurrogateKeys(t *testing.T) {
+	header := http.Header{
+		"Surrogate-Key": []string{"post-42  category-news", "post-42"},
+		"Cache-Tag":     []string{"author-7, category-news,"},
+	}
+
+	tags := SurrogateKeys(header)
+	want := []string{"post-42", "category-news", "author-7"}
+	if len(tags) != len(want) {
+		t.Fatalf("SurrogateKeys()=%v, want %v", tags, want)
+	}
+	for i := range want {
+		if tags[i] != want[i] {
+			t.Fatalf("SurrogateKeys()=%v, want %v", tags, want)
+		}
+	}
+}
+
+func TestShareable(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{"Cache-Control": []string{"Private, max-age=0"}}, false},
+		{http.Header{"Cache-Control": []string{"no-store"}}, false},
+		{http.Header{"Cache-Control": []string{"max-age=60, no-cache"}}, false},
+		{http.Header{"Set-Cookie": []string{"session=abc"}}, false},
+		{http.Header{"Cache-Control": []string{"public, max-age=60"}}, true},
+		{http.Header{}, true},
+	}
+
+	for _, test := range tests {
+		if got := Shareable(test.header); got != test.want {
+			t.Fatalf("Shareable(%v)=%v, want %v", test.header, got, test.want)
+		}
+	}
+}
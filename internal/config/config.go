@@ -13,34 +13,90 @@ const (
 	StrategyRoundRobin       = "ROUND_ROBIN"
 	StrategyLeastConnections = "LEAST_CONNECTIONS"
 
+	StrategyWeightedRoundRobin = "WEIGHTED_ROUND_ROBIN"
+	StrategyP2C                = "P2C"
+	StrategyPeakEWMA           = "PEAK_EWMA"
+	StrategyConsistentHash     = "CONSISTENT_HASH"
+
 	CacheBehaviorCache       = "CACHE"
 	CacheBehaviorPassthrough = "PASSTHROUGH"
+	CacheBehaviorCoalesce    = "COALESCE"
+
+	TTLModeFixed          = "FIXED"
+	TTLModeUpstream       = "UPSTREAM"
+	TTLModeUpstreamCapped = "UPSTREAM_CAPPED"
+
+	CacheStoreRedis  = "REDIS"
+	CacheStoreMemory = "MEMORY"
+	CacheStoreTiered = "TIERED"
 
 	DefaultEndpointKey = "DEFAULT"
 	AdminPathPrefix    = "/__doormanlb/"
 )
 
 type Config struct {
-	Services  []string                  `json:"services"`
+	Services  []Service                 `json:"services"`
 	Strategy  string                    `json:"strategy"`
 	Endpoints map[string]EndpointConfig `json:"endpoints"`
+	Cache     CacheConfig               `json:"cache,omitempty"`
+	Pools     map[string]PoolConfig     `json:"pools,omitempty"`
+	Hosts     map[string]HostConfig     `json:"hosts,omitempty"`
+
+	// endpointOrder is the order endpoints were declared in, for pattern matching.
+	endpointOrder []string
+
+	HealthCheck      HealthCheckConfig      `json:"healthCheck,omitempty"`
+	OutlierDetection OutlierDetectionConfig `json:"outlierDetection,omitempty"`
+	Retry            RetryConfig            `json:"retry,omitempty"`
+}
+
+type CacheConfig struct {
+	Store           string   `json:"store,omitempty"`
+	MaxEntries      int      `json:"maxEntries,omitempty"`
+	MaxBytes        int64    `json:"maxBytes,omitempty"`
+	L1ExpireTimeout Duration `json:"l1ExpireTimeout,omitempty"`
 }
 
 type EndpointConfig struct {
-	ExpireTimeout    int64  `json:"expireTimeout,omitempty"`
-	CacheBehavior    string `json:"cacheBehavior,omitempty"`
-	IgnoreParameters *bool  `json:"ignoreParameters,omitempty"`
+	ExpireTimeout    Duration `json:"expireTimeout,omitempty"`
+	CacheBehavior    string   `json:"cacheBehavior,omitempty"`
+	IgnoreParameters *bool    `json:"ignoreParameters,omitempty"`
+	TTLMode          string   `json:"ttlMode,omitempty"`
+
+	StaleWhileRevalidate Duration `json:"staleWhileRevalidate,omitempty"`
+	StaleIfError         Duration `json:"staleIfError,omitempty"`
+
+	HonorVary   *bool    `json:"honorVary,omitempty"`
+	VaryHeaders []string `json:"varyHeaders,omitempty"`
+
+	Pool string `json:"pool,omitempty"`
+
+	// LockTTL, FollowerMaxWait, CacheAttempts and CacheAttemptBackoff tune the
+	// leader protocol of cached endpoints; unset or zero values keep the defaults.
+	LockTTL             Duration `json:"lockTTL,omitempty"`
+	FollowerMaxWait     Duration `json:"followerMaxWait,omitempty"`
+	CacheAttempts       int      `json:"cacheAttempts,omitempty"`
+	CacheAttemptBackoff Duration `json:"cacheAttemptBackoff,omitempty"`
 }
 
+// maxCacheAttempts caps cacheAttempts, as every attempt may wait on a leader.
+const maxCacheAttempts = 10
+
 func Load(path string) (Config, error) {
 	contents, err := os.ReadFile(path)
 	if err != nil {
 		return Config{}, fmt.Errorf("reading config file %q: %w", path, err)
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(contents, &cfg); err != nil {
-		return Config{}, fmt.Errorf("decoding config file %q: %w", path, err)
+	return Parse(path, contents)
+}
+
+// Parse decodes and validates the contents of the config file at path, whose
+// extension selects the format.
+func Parse(path string, contents []byte) (Config, error) {
+	cfg, err := decode(path, contents)
+	if err != nil {
+		return Config{}, err
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -51,24 +107,33 @@ func Load(path string) (Config, error) {
 }
 
 func (c Config) Validate() error {
-	if len(c.Services) == 0 {
-		return errors.New("services must contain at least one upstream")
+	if err := c.Pool(DefaultPoolKey).validate(); err != nil {
+		return err
 	}
 
-	for i, serviceURL := range c.Services {
-		if strings.TrimSpace(serviceURL) == "" {
-			return fmt.Errorf("services[%d] cannot be empty", i)
+	for name, pool := range c.Pools {
+		if name == "" || name == DefaultPoolKey || strings.HasPrefix(name, hostPoolPrefix) {
+			return fmt.Errorf("pool name %q is reserved", name)
+		}
+		if err := pool.validate(); err != nil {
+			return fmt.Errorf("invalid pools.%s: %w", name, err)
 		}
 	}
 
-	if c.Strategy == "" {
-		return errors.New("strategy is required")
+	if err := c.Cache.validate(); err != nil {
+		return fmt.Errorf("invalid cache: %w", err)
 	}
 
-	switch c.Strategy {
-	case StrategyRoundRobin, StrategyLeastConnections:
-	default:
-		return fmt.Errorf("unsupported strategy %q", c.Strategy)
+	if err := c.HealthCheck.validate(c.AllServiceURLs()); err != nil {
+		return fmt.Errorf("invalid healthCheck: %w", err)
+	}
+
+	if err := c.OutlierDetection.validate(); err != nil {
+		return fmt.Errorf("invalid outlierDetection: %w", err)
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return fmt.Errorf("invalid retry: %w", err)
 	}
 
 	if c.Endpoints == nil {
@@ -80,7 +145,7 @@ func (c Config) Validate() error {
 		return fmt.Errorf("endpoints.%s is required", DefaultEndpointKey)
 	}
 
-	if err := validateEndpoint(defaultEndpoint, true); err != nil {
+	if err := c.validateEndpoint(defaultEndpoint, true); err != nil {
 		return fmt.Errorf("invalid endpoints.%s: %w", DefaultEndpointKey, err)
 	}
 
@@ -88,32 +153,65 @@ func (c Config) Validate() error {
 		if endpoint == DefaultEndpointKey {
 			continue
 		}
-		if endpoint == "" {
-			return errors.New("endpoint keys cannot be empty")
+		if err := validateEndpointKey(endpoint); err != nil {
+			return err
 		}
-		if strings.HasPrefix(endpoint, AdminPathPrefix) {
-			return fmt.Errorf("endpoint key %q uses reserved prefix %q", endpoint, AdminPathPrefix)
-		}
-		if err := validateEndpoint(endpointCfg, false); err != nil {
+		if err := c.validateEndpoint(endpointCfg, false); err != nil {
 			return fmt.Errorf("invalid endpoints.%s: %w", endpoint, err)
 		}
 	}
 
+	if err := validateEndpointPatterns(c.Endpoints); err != nil {
+		return err
+	}
+
 	if err := c.validateResolvedCacheExpirations(); err != nil {
 		return err
 	}
 
+	if err := c.validateHosts(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func validateEndpoint(endpointCfg EndpointConfig, requireBehavior bool) error {
-	if endpointCfg.ExpireTimeout < 0 {
+func validateEndpointKey(endpoint string) error {
+	if endpoint == "" {
+		return errors.New("endpoint keys cannot be empty")
+	}
+	if strings.HasPrefix(endpoint, AdminPathPrefix) {
+		return fmt.Errorf("endpoint key %q uses reserved prefix %q", endpoint, AdminPathPrefix)
+	}
+	return nil
+}
+
+func (c Config) validateEndpoint(endpointCfg EndpointConfig, requireBehavior bool) error {
+	if endpointCfg.ExpireTimeout.Duration() < 0 {
 		return errors.New("expireTimeout must be >= 0")
 	}
+	if endpointCfg.StaleWhileRevalidate.Duration() < 0 {
+		return errors.New("staleWhileRevalidate must be >= 0")
+	}
+	if endpointCfg.StaleIfError.Duration() < 0 {
+		return errors.New("staleIfError must be >= 0")
+	}
+	if endpointCfg.LockTTL.Duration() < 0 {
+		return errors.New("lockTTL must be >= 0")
+	}
+	if endpointCfg.FollowerMaxWait.Duration() < 0 {
+		return errors.New("followerMaxWait must be >= 0")
+	}
+	if endpointCfg.CacheAttempts < 0 || endpointCfg.CacheAttempts > maxCacheAttempts {
+		return fmt.Errorf("cacheAttempts must be between 0 and %d (0 uses the default)", maxCacheAttempts)
+	}
+	if endpointCfg.CacheAttemptBackoff.Duration() < 0 {
+		return errors.New("cacheAttemptBackoff must be >= 0")
+	}
 
 	if endpointCfg.CacheBehavior != "" {
 		switch endpointCfg.CacheBehavior {
-		case CacheBehaviorCache, CacheBehaviorPassthrough:
+		case CacheBehaviorCache, CacheBehaviorPassthrough, CacheBehaviorCoalesce:
 		default:
 			return fmt.Errorf("unsupported cacheBehavior %q", endpointCfg.CacheBehavior)
 		}
@@ -121,18 +219,84 @@ func validateEndpoint(endpointCfg EndpointConfig, requireBehavior bool) error {
 		return errors.New("cacheBehavior is required")
 	}
 
+	for i, header := range endpointCfg.VaryHeaders {
+		header = strings.TrimSpace(header)
+		if header == "" || header == "*" {
+			return fmt.Errorf("varyHeaders[%d] must name a request header", i)
+		}
+	}
+
+	switch endpointCfg.TTLMode {
+	case "", TTLModeFixed, TTLModeUpstream, TTLModeUpstreamCapped:
+	default:
+		return fmt.Errorf("unsupported ttlMode %q", endpointCfg.TTLMode)
+	}
+
+	if _, ok := c.Pools[endpointCfg.Pool]; !ok && endpointCfg.Pool != "" && endpointCfg.Pool != DefaultPoolKey {
+		return fmt.Errorf("pool %q is not defined", endpointCfg.Pool)
+	}
+
 	return nil
 }
 
+func (c CacheConfig) validate() error {
+	switch c.Store {
+	case "", CacheStoreRedis, CacheStoreMemory, CacheStoreTiered:
+	default:
+		return fmt.Errorf("unsupported store %q", c.Store)
+	}
+
+	if c.MaxEntries < 0 {
+		return errors.New("maxEntries must be >= 0")
+	}
+	if c.MaxBytes < 0 {
+		return errors.New("maxBytes must be >= 0")
+	}
+	if c.L1ExpireTimeout.Duration() < 0 {
+		return errors.New("l1ExpireTimeout must be >= 0")
+	}
+
+	return nil
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Endpoints json.RawMessage `json:"endpoints"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	decoded.endpointOrder = declaredOrder(decoded.Endpoints, objectKeys(raw.Endpoints))
+
+	*c = Config(decoded)
+	return nil
+}
+
+// Endpoint resolves the endpoint configuration of a request path: DEFAULT merged
+// with the endpoint key matching the path, if any.
 func (c Config) Endpoint(path string) EndpointConfig {
-	defaultCfg := c.Endpoints[DefaultEndpointKey]
-	override, ok := c.Endpoints[path]
+	key, ok := matchEndpoint(c.Endpoints, c.endpointOrder, path)
 	if !ok {
-		return defaultCfg
+		return c.Endpoints[DefaultEndpointKey]
 	}
+	return c.endpointByKey(key)
+}
 
-	merged := defaultCfg
-	if override.ExpireTimeout > 0 {
+func (c Config) endpointByKey(key string) EndpointConfig {
+	return c.Endpoints[DefaultEndpointKey].withOverride(c.Endpoints[key])
+}
+
+// withOverride applies the fields set in override on top of e. Durations set to
+// 0 override too, so an endpoint can turn off what DEFAULT enables.
+func (e EndpointConfig) withOverride(override EndpointConfig) EndpointConfig {
+	merged := e
+	if override.ExpireTimeout.IsSet() {
 		merged.ExpireTimeout = override.ExpireTimeout
 	}
 	if override.CacheBehavior != "" {
@@ -141,6 +305,36 @@ func (c Config) Endpoint(path string) EndpointConfig {
 	if override.IgnoreParameters != nil {
 		merged.IgnoreParameters = override.IgnoreParameters
 	}
+	if override.TTLMode != "" {
+		merged.TTLMode = override.TTLMode
+	}
+	if override.StaleWhileRevalidate.IsSet() {
+		merged.StaleWhileRevalidate = override.StaleWhileRevalidate
+	}
+	if override.StaleIfError.IsSet() {
+		merged.StaleIfError = override.StaleIfError
+	}
+	if override.HonorVary != nil {
+		merged.HonorVary = override.HonorVary
+	}
+	if override.VaryHeaders != nil {
+		merged.VaryHeaders = override.VaryHeaders
+	}
+	if override.Pool != "" {
+		merged.Pool = override.Pool
+	}
+	if override.LockTTL.IsSet() {
+		merged.LockTTL = override.LockTTL
+	}
+	if override.FollowerMaxWait.IsSet() {
+		merged.FollowerMaxWait = override.FollowerMaxWait
+	}
+	if override.CacheAttempts != 0 {
+		merged.CacheAttempts = override.CacheAttempts
+	}
+	if override.CacheAttemptBackoff.IsSet() {
+		merged.CacheAttemptBackoff = override.CacheAttemptBackoff
+	}
 
 	return merged
 }
@@ -160,20 +354,76 @@ func (c Config) UsesCache() bool {
 		}
 	}
 
+	for _, host := range c.Hosts {
+		for _, endpointCfg := range host.Endpoints {
+			if endpointCfg.CacheBehavior == CacheBehaviorCache {
+				return true
+			}
+		}
+	}
+
 	return false
 }
 
+// StaleWhileRevalidateWindow is how long after expiring an entry may still be
+// served while a single request refreshes it in the background.
+func (e EndpointConfig) StaleWhileRevalidateWindow() time.Duration {
+	return e.StaleWhileRevalidate.Duration()
+}
+
+// StaleIfErrorWindow is how long after expiring an entry may still be served
+// when refreshing it from the upstream fails.
+func (e EndpointConfig) StaleIfErrorWindow() time.Duration {
+	return e.StaleIfError.Duration()
+}
+
+// StaleRetention is how long entries are kept past their freshness lifetime.
+func (e EndpointConfig) StaleRetention() time.Duration {
+	return max(e.StaleWhileRevalidateWindow(), e.StaleIfErrorWindow())
+}
+
+// UsesUpstreamTTL reports whether cache lifetimes come from upstream response headers,
+// with expireTimeout acting as the default (and, for UPSTREAM_CAPPED, the ceiling).
+func (e EndpointConfig) UsesUpstreamTTL() bool {
+	return e.TTLMode == TTLModeUpstream || e.TTLMode == TTLModeUpstreamCapped
+}
+
+func (c CacheConfig) L1TTL() time.Duration {
+	return c.L1ExpireTimeout.Duration()
+}
+
 func (e EndpointConfig) ShouldIgnoreParameters() bool {
 	return e.IgnoreParameters != nil && *e.IgnoreParameters
 }
 
+func (e EndpointConfig) ShouldHonorVary() bool {
+	return e.HonorVary != nil && *e.HonorVary
+}
+
+// AllowsVaryOn reports whether every header a response varies on is in varyHeaders.
+func (e EndpointConfig) AllowsVaryOn(headers []string) bool {
+	for _, header := range headers {
+		allowed := false
+		for _, candidate := range e.VaryHeaders {
+			if strings.EqualFold(strings.TrimSpace(candidate), header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
 func (e EndpointConfig) CacheTTL() time.Duration {
-	return time.Duration(e.ExpireTimeout) * time.Millisecond
+	return e.ExpireTimeout.Duration()
 }
 
 func (c Config) validateResolvedCacheExpirations() error {
-	defaultCfg := c.Endpoint(DefaultEndpointKey)
-	if defaultCfg.CacheBehavior == CacheBehaviorCache && defaultCfg.ExpireTimeout <= 0 {
+	defaultCfg := c.endpointByKey(DefaultEndpointKey)
+	if defaultCfg.CacheBehavior == CacheBehaviorCache && defaultCfg.CacheTTL() <= 0 {
 		return fmt.Errorf("endpoints.%s.expireTimeout must be > 0 when cacheBehavior is %q", DefaultEndpointKey, CacheBehaviorCache)
 	}
 
@@ -181,8 +431,8 @@ func (c Config) validateResolvedCacheExpirations() error {
 		if endpoint == DefaultEndpointKey {
 			continue
 		}
-		resolved := c.Endpoint(endpoint)
-		if resolved.CacheBehavior == CacheBehaviorCache && resolved.ExpireTimeout <= 0 {
+		resolved := c.endpointByKey(endpoint)
+		if resolved.CacheBehavior == CacheBehaviorCache && resolved.CacheTTL() <= 0 {
 			return fmt.Errorf("endpoints.%s resolves to CACHE but has no positive expireTimeout", endpoint)
 		}
 	}
@@ -1,10 +1,15 @@
 package config
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestValidateRequiresDefaultEndpoint(t *testing.T) {
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyRoundRobin,
 		Endpoints: map[string]EndpointConfig{
 			"/": {CacheBehavior: CacheBehaviorPassthrough},
@@ -21,11 +26,11 @@ func TestEndpointMergesDefaultWithOverride(t *testing.T) {
 	ignoreParameters := true
 
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyLeastConnections,
 		Endpoints: map[string]EndpointConfig{
 			DefaultEndpointKey: {
-				ExpireTimeout:    600_000,
+				ExpireTimeout:    Milliseconds(600_000),
 				CacheBehavior:    CacheBehaviorCache,
 				IgnoreParameters: boolPtr(false),
 			},
@@ -37,8 +42,8 @@ func TestEndpointMergesDefaultWithOverride(t *testing.T) {
 	}
 
 	endpoint := cfg.Endpoint("/health")
-	if endpoint.ExpireTimeout != 600_000 {
-		t.Fatalf("expected default expireTimeout, got %d", endpoint.ExpireTimeout)
+	if endpoint.CacheTTL() != 10*time.Minute {
+		t.Fatalf("expected default expireTimeout, got %s", endpoint.CacheTTL())
 	}
 	if endpoint.CacheBehavior != CacheBehaviorPassthrough {
 		t.Fatalf("expected override cacheBehavior PASSTHROUGH, got %s", endpoint.CacheBehavior)
@@ -50,7 +55,7 @@ func TestEndpointMergesDefaultWithOverride(t *testing.T) {
 
 func TestUsesCache(t *testing.T) {
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyRoundRobin,
 		Endpoints: map[string]EndpointConfig{
 			DefaultEndpointKey: {
@@ -69,7 +74,7 @@ func TestUsesCache(t *testing.T) {
 
 func TestValidateRejectsReservedAdminPrefix(t *testing.T) {
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyRoundRobin,
 		Endpoints: map[string]EndpointConfig{
 			DefaultEndpointKey: {
@@ -89,12 +94,12 @@ func TestValidateRejectsReservedAdminPrefix(t *testing.T) {
 
 func TestValidateRequiresPositiveTTLWhenCachingDefault(t *testing.T) {
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyRoundRobin,
 		Endpoints: map[string]EndpointConfig{
 			DefaultEndpointKey: {
 				CacheBehavior: CacheBehaviorCache,
-				ExpireTimeout: 0,
+				ExpireTimeout: Milliseconds(0),
 			},
 		},
 	}
@@ -107,16 +112,16 @@ func TestValidateRequiresPositiveTTLWhenCachingDefault(t *testing.T) {
 
 func TestValidateRequiresPositiveResolvedTTLForOverride(t *testing.T) {
 	cfg := Config{
-		Services: []string{"http://svc-a:8080"},
+		Services: []Service{{URL: "http://svc-a:8080"}},
 		Strategy: StrategyRoundRobin,
 		Endpoints: map[string]EndpointConfig{
 			DefaultEndpointKey: {
 				CacheBehavior: CacheBehaviorPassthrough,
-				ExpireTimeout: 0,
+				ExpireTimeout: Milliseconds(0),
 			},
 			"/cached": {
 				CacheBehavior: CacheBehaviorCache,
-				ExpireTimeout: 0,
+				ExpireTimeout: Milliseconds(0),
 			},
 		},
 	}
@@ -130,3 +135,621 @@ func TestValidateRequiresPositiveResolvedTTLForOverride(t *testing.T) {
 func boolPtr(value bool) *bool {
 	return &value
 }
+
+func TestValidateAcceptsCoalesceWithoutCache(t *testing.T) {
+	cfg := Config{
+		Services: []Service{{URL: "http://svc-a:8080"}},
+		Strategy: StrategyRoundRobin,
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {CacheBehavior: CacheBehaviorCoalesce},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected COALESCE to be valid, got %v", err)
+	}
+	if cfg.UsesCache() {
+		t.Fatal("expected COALESCE endpoints not to require a cache store")
+	}
+}
+
+func TestValidateRejectsUnknownCacheStore(t *testing.T) {
+	cfg := Config{
+		Services: []Service{{URL: "http://svc-a:8080"}},
+		Strategy: StrategyRoundRobin,
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough},
+		},
+		Cache: CacheConfig{Store: "MEMCACHED"},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unsupported cache store")
+	}
+
+	cfg.Cache.Store = CacheStoreMemory
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected MEMORY cache store to be valid, got %v", err)
+	}
+}
+
+func TestValidateRejectsUnknownTTLMode(t *testing.T) {
+	cfg := Config{
+		Services: []Service{{URL: "http://svc-a:8080"}},
+		Strategy: StrategyRoundRobin,
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {
+				CacheBehavior: CacheBehaviorCache,
+				ExpireTimeout: Milliseconds(60_000),
+			},
+			"/feed": {TTLMode: "HEURISTIC"},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for unsupported ttlMode")
+	}
+
+	cfg.Endpoints["/feed"] = EndpointConfig{TTLMode: TTLModeUpstreamCapped}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected UPSTREAM_CAPPED to be valid, got %v", err)
+	}
+	if got := cfg.Endpoint("/feed"); !got.UsesUpstreamTTL() {
+		t.Fatal("expected /feed to resolve to an upstream ttl mode")
+	}
+}
+
+func TestAllowsVaryOnUsesAllowlist(t *testing.T) {
+	endpoint := EndpointConfig{
+		HonorVary:   boolPtr(true),
+		VaryHeaders: []string{"accept-encoding", "Accept-Language"},
+	}
+
+	if !endpoint.AllowsVaryOn([]string{"Accept-Encoding", "Accept-Language"}) {
+		t.Fatal("expected allowlisted headers to be accepted regardless of case")
+	}
+	if endpoint.AllowsVaryOn([]string{"Accept-Encoding", "Cookie"}) {
+		t.Fatal("expected Cookie to be rejected when not allowlisted")
+	}
+}
+
+func TestValidateRejectsWildcardVaryHeader(t *testing.T) {
+	cfg := Config{
+		Services: []Service{{URL: "http://svc-a:8080"}},
+		Strategy: StrategyRoundRobin,
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {
+				CacheBehavior: CacheBehaviorCache,
+				ExpireTimeout: Milliseconds(60_000),
+				HonorVary:     boolPtr(true),
+				VaryHeaders:   []string{"*"},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected validation error for wildcard vary header")
+	}
+}
+
+func TestHealthCheckForAppliesServiceOverride(t *testing.T) {
+	healthCheck := HealthCheckConfig{
+		Path:     "/healthz",
+		Interval: Milliseconds(5_000),
+		Services: map[string]HealthCheckConfig{
+			"http://svc-b": {Path: "/status", ExpectedStatus: 204},
+		},
+	}
+
+	svcA := healthCheck.For("http://svc-a")
+	if svcA.Path != "/healthz" || svcA.Status() != 200 || svcA.IntervalDuration() != 5*time.Second {
+		t.Fatalf("unexpected default probe %+v", svcA)
+	}
+	if svcA.HealthyAfter() != 2 || svcA.UnhealthyAfter() != 3 || svcA.TimeoutDuration() != 2*time.Second {
+		t.Fatalf("unexpected probe defaults %+v", svcA)
+	}
+
+	svcB := healthCheck.For("http://svc-b")
+	if svcB.Path != "/status" || svcB.Status() != 204 || svcB.IntervalDuration() != 5*time.Second {
+		t.Fatalf("unexpected overridden probe %+v", svcB)
+	}
+}
+
+func TestValidateRejectsInvalidHealthCheck(t *testing.T) {
+	tests := map[string]HealthCheckConfig{
+		"relative path":   {Path: "healthz"},
+		"negative":        {Path: "/healthz", Interval: Milliseconds(-1)},
+		"bad status":      {Path: "/healthz", ExpectedStatus: 42},
+		"unknown service": {Path: "/healthz", Services: map[string]HealthCheckConfig{"http://other": {}}},
+	}
+
+	for name, healthCheck := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services:    []Service{{URL: "http://svc-a"}},
+				Strategy:    StrategyRoundRobin,
+				Endpoints:   map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+				HealthCheck: healthCheck,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestOutlierDetectionDefaults(t *testing.T) {
+	outliers := OutlierDetectionConfig{ConsecutiveFailures: 5}
+	if outliers.BaseEjectionDuration() != 30*time.Second || outliers.MaxEjectionDuration() != 5*time.Minute {
+		t.Fatalf("unexpected ejection times %s/%s", outliers.BaseEjectionDuration(), outliers.MaxEjectionDuration())
+	}
+	if outliers.EjectablePercent() != 50 {
+		t.Fatalf("expected 50%% ejectable by default, got %d", outliers.EjectablePercent())
+	}
+
+	zero := 0
+	outliers.MaxEjectionPercent = &zero
+	if outliers.EjectablePercent() != 0 {
+		t.Fatalf("expected explicit 0%% to be kept, got %d", outliers.EjectablePercent())
+	}
+}
+
+func TestValidateRejectsInvalidOutlierDetection(t *testing.T) {
+	tooMany := 150
+	tests := map[string]OutlierDetectionConfig{
+		"negative failures":    {ConsecutiveFailures: -1},
+		"negative ejection":    {ConsecutiveFailures: 5, BaseEjectionTime: Milliseconds(-1)},
+		"max below base":       {ConsecutiveFailures: 5, BaseEjectionTime: Milliseconds(10_000), MaxEjectionTime: Milliseconds(1_000)},
+		"percent out of range": {ConsecutiveFailures: 5, MaxEjectionPercent: &tooMany},
+	}
+
+	for name, outliers := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services:         []Service{{URL: "http://svc-a"}},
+				Strategy:         StrategyRoundRobin,
+				Endpoints:        map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+				OutlierDetection: outliers,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestRetryDefaults(t *testing.T) {
+	retry := RetryConfig{MaxAttempts: 3}
+	if !retry.Enabled() || !retry.RetriesConnectErrors() {
+		t.Fatalf("expected retries on connect errors by default")
+	}
+	if !retry.RetriesStatus(503) || retry.RetriesStatus(500) {
+		t.Fatal("expected only 502, 503 and 504 to be retried by default")
+	}
+	if retry.Budget() != 20 || retry.MinConcurrency() != 3 {
+		t.Fatalf("unexpected budget defaults %d/%d", retry.Budget(), retry.MinConcurrency())
+	}
+
+	retry.RetryStatusCodes = []int{}
+	if retry.RetriesStatus(503) {
+		t.Fatal("expected an explicit empty list to retry no statuses")
+	}
+}
+
+func TestValidateRejectsInvalidRetry(t *testing.T) {
+	negative := -1
+	tests := map[string]RetryConfig{
+		"negative attempts": {MaxAttempts: -1},
+		"bad status":        {MaxAttempts: 2, RetryStatusCodes: []int{42}},
+		"negative timeout":  {MaxAttempts: 2, PerTryTimeout: Milliseconds(-1)},
+		"negative budget":   {MaxAttempts: 2, BudgetPercent: &negative},
+	}
+
+	for name, retry := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services:  []Service{{URL: "http://svc-a"}},
+				Strategy:  StrategyRoundRobin,
+				Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+				Retry:     retry,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestServicesAcceptStringsAndWeightedObjects(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"services": ["http://svc-a", {"url": "http://svc-b", "weight": 3}]}`), &cfg)
+	if err != nil {
+		t.Fatalf("decoding services: %v", err)
+	}
+
+	want := []Service{{URL: "http://svc-a"}, {URL: "http://svc-b", Weight: 3}}
+	if !reflect.DeepEqual(cfg.Services, want) {
+		t.Fatalf("expected %+v, got %+v", want, cfg.Services)
+	}
+	if cfg.Services[0].EffectiveWeight() != 1 || cfg.Services[1].EffectiveWeight() != 3 {
+		t.Fatalf("unexpected weights %+v", cfg.Services)
+	}
+}
+
+func TestValidateWeightedServices(t *testing.T) {
+	cfg := Config{
+		Services:  []Service{{URL: "http://svc-a", Weight: 2}, {URL: "http://svc-b"}},
+		Strategy:  StrategyWeightedRoundRobin,
+		Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected weighted services to validate: %v", err)
+	}
+
+	cfg.Services[1].Weight = -1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected negative weight to be rejected")
+	}
+
+	if err := json.Unmarshal([]byte(`{"services": [{"url": "http://svc-a", "weight": 0}]}`), &cfg); err == nil {
+		t.Fatal("expected an explicit zero weight to be rejected")
+	}
+}
+
+func TestEndpointPoolsResolve(t *testing.T) {
+	cfg := Config{
+		Services: []Service{{URL: "http://web"}},
+		Strategy: StrategyRoundRobin,
+		Pools: map[string]PoolConfig{
+			"api": {Services: []Service{{URL: "http://api-a"}, {URL: "http://api-b"}}, Strategy: StrategyLeastConnections},
+		},
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough},
+			"/api":             {Pool: "api"},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected pools to validate: %v", err)
+	}
+
+	if pool := cfg.Pool(cfg.Endpoint("/api").Pool); pool.Strategy != StrategyLeastConnections || len(pool.Services) != 2 {
+		t.Fatalf("expected /api to use the api pool, got %+v", pool)
+	}
+	if pool := cfg.Pool(cfg.Endpoint("/").Pool); pool.Services[0].URL != "http://web" {
+		t.Fatalf("expected / to use the default pool, got %+v", pool)
+	}
+	if got := cfg.AllServiceURLs(); !reflect.DeepEqual(got, []string{"http://web", "http://api-a", "http://api-b"}) {
+		t.Fatalf("unexpected service URLs %v", got)
+	}
+}
+
+func TestValidateRejectsInvalidPools(t *testing.T) {
+	tests := map[string]struct {
+		pools    map[string]PoolConfig
+		endpoint EndpointConfig
+	}{
+		"undefined pool": {endpoint: EndpointConfig{Pool: "api"}},
+		"reserved name": {
+			pools: map[string]PoolConfig{DefaultPoolKey: {Services: []Service{{URL: "http://api"}}, Strategy: StrategyRoundRobin}},
+		},
+		"empty pool":       {pools: map[string]PoolConfig{"api": {Strategy: StrategyRoundRobin}}},
+		"unknown strategy": {pools: map[string]PoolConfig{"api": {Services: []Service{{URL: "http://api"}}, Strategy: "RANDOM"}}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services: []Service{{URL: "http://web"}},
+				Strategy: StrategyRoundRobin,
+				Pools:    tc.pools,
+				Endpoints: map[string]EndpointConfig{
+					DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough},
+					"/api":             tc.endpoint,
+				},
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func hostsConfig() Config {
+	return Config{
+		Services: []Service{{URL: "http://default"}},
+		Strategy: StrategyRoundRobin,
+		Endpoints: map[string]EndpointConfig{
+			DefaultEndpointKey: {CacheBehavior: CacheBehaviorCache, ExpireTimeout: Milliseconds(1000)},
+			"/health":          {CacheBehavior: CacheBehaviorPassthrough},
+		},
+		Hosts: map[string]HostConfig{
+			"blog.example.com": {
+				Services: []Service{{URL: "http://blog"}},
+				Endpoints: map[string]EndpointConfig{
+					DefaultEndpointKey: {ExpireTimeout: Milliseconds(5000)},
+					"/feed":            {ExpireTimeout: Milliseconds(60_000)},
+				},
+			},
+			"*.example.com":     {Services: []Service{{URL: "http://sites"}}, Strategy: StrategyLeastConnections},
+			"*.api.example.com": {Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}}},
+		},
+	}
+}
+
+func TestMatchHostPrefersExactThenLongestWildcard(t *testing.T) {
+	cfg := hostsConfig()
+	tests := map[string]string{
+		"blog.example.com":      "blog.example.com",
+		"Blog.Example.com:8080": "blog.example.com",
+		"shop.example.com":      "*.example.com",
+		"v1.api.example.com":    "*.api.example.com",
+	}
+	for host, want := range tests {
+		if got, ok := cfg.MatchHost(host); !ok || got != want {
+			t.Fatalf("expected %s to match %s, got %q", host, want, got)
+		}
+	}
+
+	for _, host := range []string{"example.com", "other.org", ""} {
+		if got, ok := cfg.MatchHost(host); ok {
+			t.Fatalf("expected %q to match no host, got %s", host, got)
+		}
+	}
+}
+
+func TestEndpointForInheritsPerHost(t *testing.T) {
+	cfg := hostsConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected hosts to validate: %v", err)
+	}
+
+	feed := cfg.EndpointFor("blog.example.com", "/feed")
+	if feed.CacheBehavior != CacheBehaviorCache || feed.CacheTTL() != time.Minute || feed.Pool != HostPoolKey("blog.example.com") {
+		t.Fatalf("unexpected /feed endpoint %+v", feed)
+	}
+	if page := cfg.EndpointFor("blog.example.com", "/about"); page.CacheTTL() != 5*time.Second {
+		t.Fatalf("expected the host DEFAULT to apply, got %+v", page)
+	}
+	// Top-level path overrides do not leak into hosts, only the top-level DEFAULT.
+	if health := cfg.EndpointFor("blog.example.com", "/health"); health.CacheBehavior != CacheBehaviorCache {
+		t.Fatalf("expected host endpoints to ignore top-level /health, got %+v", health)
+	}
+	if health := cfg.EndpointFor("other.org", "/health"); health.CacheBehavior != CacheBehaviorPassthrough || health.Pool != "" {
+		t.Fatalf("expected unmatched hosts to use the top-level endpoints, got %+v", health)
+	}
+	if api := cfg.EndpointFor("v1.api.example.com", "/"); api.CacheBehavior != CacheBehaviorPassthrough || api.Pool != "" {
+		t.Fatalf("expected a host without services to use the DEFAULT pool, got %+v", api)
+	}
+
+	if pool := cfg.Pool(HostPoolKey("*.example.com")); pool.Strategy != StrategyLeastConnections || pool.Services[0].URL != "http://sites" {
+		t.Fatalf("unexpected host pool %+v", pool)
+	}
+	if pool := cfg.Pool(HostPoolKey("blog.example.com")); pool.Strategy != StrategyRoundRobin {
+		t.Fatalf("expected host pools to inherit the top-level strategy, got %+v", pool)
+	}
+}
+
+func TestValidateRejectsInvalidHosts(t *testing.T) {
+	tests := map[string]map[string]HostConfig{
+		"uppercase":         {"Example.com": {}},
+		"port":              {"example.com:80": {}},
+		"inner wildcard":    {"www.*.com": {}},
+		"strategy only":     {"example.com": {Strategy: StrategyRoundRobin}},
+		"bad endpoint":      {"example.com": {Endpoints: map[string]EndpointConfig{"/": {TTLMode: "SOMETIMES"}}}},
+		"cache without ttl": {"example.com": {Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorCache}}}},
+	}
+
+	for name, hosts := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services:  []Service{{URL: "http://default"}},
+				Strategy:  StrategyRoundRobin,
+				Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+				Hosts:     hosts,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestEndpointMatchingPrecedence(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+		"services": ["http://svc-a"],
+		"strategy": "ROUND_ROBIN",
+		"endpoints": {
+			"DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": 1000},
+			"~/wp-content/uploads/[0-9]{4}/.*": {"expireTimeout": 4},
+			"/wp-content/*/*.jpg": {"expireTimeout": 5},
+			"/wp-content/*": {"expireTimeout": 2},
+			"/wp-content/uploads/*": {"expireTimeout": 3},
+			"/wp-content/plugins/readme.txt": {"expireTimeout": 1},
+			"~^/api/v[0-9]+$": {"cacheBehavior": "PASSTHROUGH"}
+		}
+	}`), &cfg)
+	if err != nil {
+		t.Fatalf("decoding config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected patterns to validate: %v", err)
+	}
+
+	tests := map[string]int64{
+		"/wp-content/plugins/readme.txt":     1,
+		"/wp-content/themes/style.css":       2,
+		"/wp-content/uploads/2024/01/a.jpg":  3,
+		"/about":                             1000,
+		"/feeds/2024/x.jpg":                  1000,
+		"/wp-contentious":                    1000,
+		"/assets/logo.jpg":                   1000,
+		"/static/img/2024/photo.jpg":         1000,
+		"/media/uploads/2024/01/photo.jpg":   1000,
+		"/wp-content/uploads":                2,
+		"/wp-content/uploads/":               3,
+		"/wp-content/plugins/readme.txt.bak": 2,
+	}
+	for path, want := range tests {
+		if got := cfg.Endpoint(path).ExpireTimeout; got != Milliseconds(want) {
+			t.Fatalf("expected %s to resolve expireTimeout %d, got %s", path, want, got)
+		}
+	}
+
+	if got := cfg.Endpoint("/api/v2").CacheBehavior; got != CacheBehaviorPassthrough {
+		t.Fatalf("expected the anchored regex to match /api/v2, got %s", got)
+	}
+	if got := cfg.Endpoint("/api/v2/users").CacheBehavior; got != CacheBehaviorCache {
+		t.Fatalf("expected the regex to be anchored at the end, got %s", got)
+	}
+}
+
+func TestEndpointPatternsFollowDeclaredOrder(t *testing.T) {
+	for _, tc := range []struct {
+		endpoints string
+		want      int64
+	}{
+		{`{"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}, "~/blog/.*": {"expireTimeout": 1}, "/blog/*.html": {"expireTimeout": 2}}`, 1},
+		{`{"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}, "/blog/*.html": {"expireTimeout": 2}, "~/blog/.*": {"expireTimeout": 1}}`, 2},
+	} {
+		var cfg Config
+		if err := json.Unmarshal([]byte(`{"endpoints": `+tc.endpoints+`}`), &cfg); err != nil {
+			t.Fatalf("decoding config: %v", err)
+		}
+		if got := cfg.Endpoint("/blog/post.html").ExpireTimeout; got != Milliseconds(tc.want) {
+			t.Fatalf("expected the first declared pattern in %s to win, got %s", tc.endpoints, got)
+		}
+	}
+}
+
+func TestValidateRejectsBadEndpointPatterns(t *testing.T) {
+	tests := map[string][]string{
+		"bad regex":         {"~/api/(v1"},
+		"bad glob":          {"/files/[a-"},
+		"duplicate prefix":  {"/docs/*", "/docs/**"},
+		"duplicate regex":   {"~/api/.*", "~^/api/.*$"},
+		"regex matches all": {"~.*", "~^.*$"},
+	}
+
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{
+				Services:  []Service{{URL: "http://svc-a"}},
+				Strategy:  StrategyRoundRobin,
+				Endpoints: map[string]EndpointConfig{DefaultEndpointKey: {CacheBehavior: CacheBehaviorPassthrough}},
+			}
+			for _, key := range keys {
+				cfg.Endpoints[key] = EndpointConfig{}
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestDurationsAcceptMillisecondsAndStrings(t *testing.T) {
+	tests := map[string]Duration{
+		`60000`:     Milliseconds(60000),
+		`"90s"`:     NewDuration(90 * time.Second),
+		`"1h30m"`:   NewDuration(90 * time.Minute),
+		`"1500"`:    Milliseconds(1500),
+		`0`:         Milliseconds(0),
+		`"0s"`:      Milliseconds(0),
+		`"inherit"`: {},
+		`null`:      {},
+	}
+	for input, want := range tests {
+		var got Duration
+		if err := json.Unmarshal([]byte(input), &got); err != nil {
+			t.Fatalf("decoding %s: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("expected %s to decode as %s, got %s", input, want, got)
+		}
+	}
+
+	for _, input := range []string{`"10 minutes"`, `"5"s`, `true`} {
+		var got Duration
+		if err := json.Unmarshal([]byte(input), &got); err == nil {
+			t.Fatalf("expected %s to be rejected, got %s", input, got)
+		}
+	}
+}
+
+func TestExplicitZeroDurationsOverrideDefault(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{
+		"services": ["http://svc-a:8080"],
+		"strategy": "ROUND_ROBIN",
+		"endpoints": {
+			"DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": "10m", "staleWhileRevalidate": "1m"},
+			"/live": {"staleWhileRevalidate": 0},
+			"/inherited": {"staleWhileRevalidate": "inherit", "expireTimeout": 90000},
+			"/proxied": {"cacheBehavior": "PASSTHROUGH", "expireTimeout": 0}
+		}
+	}`), &cfg); err != nil {
+		t.Fatalf("decoding config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected config to validate: %v", err)
+	}
+
+	if live := cfg.Endpoint("/live"); live.StaleWhileRevalidateWindow() != 0 || live.CacheTTL() != 10*time.Minute {
+		t.Fatalf("expected an explicit 0 to turn off staleWhileRevalidate, got %+v", live)
+	}
+	if inherited := cfg.Endpoint("/inherited"); inherited.StaleWhileRevalidateWindow() != time.Minute || inherited.CacheTTL() != 90*time.Second {
+		t.Fatalf("expected inherit to keep DEFAULT's staleWhileRevalidate, got %+v", inherited)
+	}
+	if proxied := cfg.Endpoint("/proxied"); proxied.CacheTTL() != 0 {
+		t.Fatalf("expected an explicit 0 expireTimeout, got %s", proxied.CacheTTL())
+	}
+
+	cfg.Endpoints["/live"] = EndpointConfig{ExpireTimeout: Milliseconds(0)}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected a cached endpoint with an explicit 0 expireTimeout to be rejected")
+	}
+}
+
+func TestLeaderProtocolSettingsInheritAndValidate(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{
+		"services": ["http://svc-a:8080"],
+		"strategy": "ROUND_ROBIN",
+		"endpoints": {
+			"DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": "10m", "followerMaxWait": "2s", "cacheAttempts": 2},
+			"/reports/*": {"lockTTL": "90s", "followerMaxWait": "3m", "cacheAttemptBackoff": "1s"}
+		}
+	}`), &cfg); err != nil {
+		t.Fatalf("decoding config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected config to validate: %v", err)
+	}
+
+	reports := cfg.Endpoint("/reports/monthly")
+	want := EndpointConfig{
+		ExpireTimeout:       NewDuration(10 * time.Minute),
+		CacheBehavior:       CacheBehaviorCache,
+		LockTTL:             NewDuration(90 * time.Second),
+		FollowerMaxWait:     NewDuration(3 * time.Minute),
+		CacheAttempts:       2,
+		CacheAttemptBackoff: NewDuration(time.Second),
+	}
+	if !reflect.DeepEqual(reports, want) {
+		t.Fatalf("expected %+v, got %+v", want, reports)
+	}
+
+	for name, endpoint := range map[string]EndpointConfig{
+		"negative lockTTL":             {LockTTL: Milliseconds(-1)},
+		"negative followerMaxWait":     {FollowerMaxWait: Milliseconds(-1)},
+		"negative cacheAttempts":       {CacheAttempts: -1},
+		"too many cacheAttempts":       {CacheAttempts: maxCacheAttempts + 1},
+		"negative cacheAttemptBackoff": {CacheAttemptBackoff: Milliseconds(-1)},
+	} {
+		cfg.Endpoints["/reports/*"] = endpoint
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
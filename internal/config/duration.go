@@ -0,0 +1,126 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inheritDuration is the value that leaves a duration unset, so it is inherited
+// (from DEFAULT, for endpoints) or takes its default.
+const inheritDuration = "inherit"
+
+// Duration is a length of time in a config file, written either as a number of
+// milliseconds (60000) or as a duration string such as "90s", "10m" or "1h30m".
+// The zero Duration is unset, which is also what null and "inherit" decode to;
+// an explicit 0 is set, so it can override an inherited value.
+type Duration struct {
+	value time.Duration
+	set   bool
+}
+
+// Milliseconds returns a set Duration of ms milliseconds.
+func Milliseconds(ms int64) Duration {
+	return Duration{value: time.Duration(ms) * time.Millisecond, set: true}
+}
+
+// NewDuration returns a set Duration of d.
+func NewDuration(d time.Duration) Duration {
+	return Duration{value: d, set: true}
+}
+
+// IsSet reports whether the duration was given, even if as 0.
+func (d Duration) IsSet() bool {
+	return d.set
+}
+
+// Duration returns the duration, or 0 if it is unset.
+func (d Duration) Duration() time.Duration {
+	return d.value
+}
+
+// Positive returns the duration if it is above zero, and fallback otherwise, for
+// settings where zero has no meaning of its own.
+func (d Duration) Positive(fallback time.Duration) time.Duration {
+	if d.value <= 0 {
+		return fallback
+	}
+	return d.value
+}
+
+func (d Duration) String() string {
+	if !d.set {
+		return inheritDuration
+	}
+	return d.value.String()
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = Duration{}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var ms float64
+		if err := json.Unmarshal(data, &ms); err != nil {
+			return newValueError(data, fmt.Errorf("invalid duration %s: use milliseconds or a string such as \"90s\"", data))
+		}
+		*d = NewDuration(time.Duration(ms * float64(time.Millisecond)))
+		return nil
+	}
+
+	parsed, err := ParseDuration(text)
+	if err != nil {
+		return newValueError(data, err)
+	}
+	*d = parsed
+	return nil
+}
+
+// valueError is an invalid value found while decoding a config file. It keeps
+// the value as encoded so decode can report where in the file it came from,
+// which encoding/json does not do for errors of UnmarshalJSON methods.
+type valueError struct {
+	value []byte
+	err   error
+}
+
+func newValueError(value []byte, err error) *valueError {
+	return &valueError{value: append([]byte(nil), value...), err: err}
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if !d.set {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.value.String())
+}
+
+// ParseDuration parses a duration as written in a config file: "inherit", a
+// number of milliseconds, or a Go duration string such as "1h30m".
+func ParseDuration(text string) (Duration, error) {
+	text = strings.TrimSpace(text)
+	if strings.EqualFold(text, inheritDuration) {
+		return Duration{}, nil
+	}
+	if ms, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return Milliseconds(ms), nil
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return Duration{}, fmt.Errorf("invalid duration %q: use milliseconds or a string such as \"90s\"", text)
+	}
+	return NewDuration(value), nil
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Endpoint keys are matched against the request path in this order of kinds.
+// An exact key is a plain path. A key ending in * matches every path starting
+// with the rest of it (the longest such prefix wins). Other keys with *, ? or [
+// are globs with path.Match syntax, and keys starting with ~ are regular
+// expressions matched against the whole path; globs and regular expressions are
+// tried in the order they are declared in the config file.
+const regexEndpointPrefix = "~"
+
+type endpointKind int
+
+const (
+	endpointExact endpointKind = iota
+	endpointPrefix
+	endpointGlob
+	endpointRegex
+)
+
+type endpointPattern struct {
+	kind endpointKind
+	// pattern is the normalized prefix, glob or expression; keys with equal kind
+	// and pattern match the same paths.
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// endpointPatterns caches parsed keys, since endpoints are resolved per request.
+var endpointPatterns sync.Map
+
+func parseEndpointKey(key string) (endpointPattern, error) {
+	if cached, ok := endpointPatterns.Load(key); ok {
+		return cached.(endpointPattern), nil
+	}
+
+	var parsed endpointPattern
+	switch {
+	case strings.HasPrefix(key, regexEndpointPrefix):
+		expression := strings.TrimPrefix(strings.TrimPrefix(key, regexEndpointPrefix), "^")
+		if strings.HasSuffix(expression, "$") && !strings.HasSuffix(expression, `\$`) {
+			expression = strings.TrimSuffix(expression, "$")
+		}
+		regex, err := regexp.Compile("^(?:" + expression + ")$")
+		if err != nil {
+			return endpointPattern{}, fmt.Errorf("endpoint key %q is not a valid regular expression: %w", key, err)
+		}
+		parsed = endpointPattern{kind: endpointRegex, pattern: expression, regex: regex}
+	case strings.HasSuffix(key, "*") && !strings.ContainsAny(strings.TrimRight(key, "*"), "*?["):
+		parsed = endpointPattern{kind: endpointPrefix, pattern: strings.TrimRight(key, "*")}
+	case strings.ContainsAny(key, "*?["):
+		if _, err := path.Match(key, ""); err != nil {
+			return endpointPattern{}, fmt.Errorf("endpoint key %q is not a valid glob: %w", key, err)
+		}
+		parsed = endpointPattern{kind: endpointGlob, pattern: key}
+	default:
+		parsed = endpointPattern{kind: endpointExact, pattern: key}
+	}
+
+	endpointPatterns.Store(key, parsed)
+	return parsed, nil
+}
+
+func (p endpointPattern) matches(requestPath string) bool {
+	switch p.kind {
+	case endpointPrefix:
+		return strings.HasPrefix(requestPath, p.pattern)
+	case endpointGlob:
+		matched, _ := path.Match(p.pattern, requestPath)
+		return matched
+	case endpointRegex:
+		return p.regex.MatchString(requestPath)
+	default:
+		return requestPath == p.pattern
+	}
+}
+
+// matchEndpoint finds the key of endpoints that applies to requestPath: an exact
+// key, else the longest matching prefix, else the first matching glob or regular
+// expression in order. Decoding lists every key in order; configs built in code
+// have theirs sorted on each call. DEFAULT is never matched.
+func matchEndpoint(endpoints map[string]EndpointConfig, order []string, requestPath string) (string, bool) {
+	if _, ok := endpoints[requestPath]; ok && requestPath != DefaultEndpointKey {
+		if parsed, err := parseEndpointKey(requestPath); err == nil && parsed.kind == endpointExact {
+			return requestPath, true
+		}
+	}
+
+	matched, matchedLength := "", -1
+	for key := range endpoints {
+		parsed, err := parseEndpointKey(key)
+		if err != nil || parsed.kind != endpointPrefix || !parsed.matches(requestPath) {
+			continue
+		}
+		if len(parsed.pattern) > matchedLength || (len(parsed.pattern) == matchedLength && key < matched) {
+			matched, matchedLength = key, len(parsed.pattern)
+		}
+	}
+	if matched != "" {
+		return matched, true
+	}
+
+	if len(order) != len(endpoints) {
+		order = declaredOrder(endpoints, order)
+	}
+	for _, key := range order {
+		parsed, err := parseEndpointKey(key)
+		if err != nil || (parsed.kind != endpointGlob && parsed.kind != endpointRegex) {
+			continue
+		}
+		if parsed.matches(requestPath) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// declaredOrder lists the keys of endpoints in the order they were declared in
+// the config file. Keys not seen while decoding (such as in configs built in
+// code) follow in sorted order.
+func declaredOrder(endpoints map[string]EndpointConfig, order []string) []string {
+	keys := make([]string, 0, len(endpoints))
+	seen := make(map[string]bool, len(endpoints))
+	for _, key := range order {
+		if _, ok := endpoints[key]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	var rest []string
+	for key := range endpoints {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// validateEndpointPatterns compiles every endpoint key and rejects keys that
+// match exactly the same paths, such as /docs/* and /docs/**.
+func validateEndpointPatterns(endpoints map[string]EndpointConfig) error {
+	owners := make(map[endpointPattern]string, len(endpoints))
+	for _, key := range declaredOrder(endpoints, nil) {
+		if key == DefaultEndpointKey {
+			continue
+		}
+		parsed, err := parseEndpointKey(key)
+		if err != nil {
+			return err
+		}
+		identity := endpointPattern{kind: parsed.kind, pattern: parsed.pattern}
+		if other, ok := owners[identity]; ok {
+			return fmt.Errorf("endpoint keys %q and %q match the same paths", other, key)
+		}
+		owners[identity] = key
+	}
+	return nil
+}
+
+// objectKeys lists the keys of a JSON object in document order.
+func objectKeys(raw json.RawMessage) []string {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := token.(string)
+		keys = append(keys, key)
+
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
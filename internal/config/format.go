@@ -0,0 +1,431 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseError is a problem at a line and column of a config file. Column is 0
+// when only the line is known.
+type ParseError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+type position struct {
+	line   int
+	column int
+}
+
+func errorAt(pos position, format string, args ...any) error {
+	return &ParseError{Line: pos.line, Column: pos.column, Err: fmt.Errorf(format, args...)}
+}
+
+type docKind int
+
+const (
+	docNull docKind = iota
+	docBool
+	docNumber
+	docString
+	docObject
+	docArray
+)
+
+// docValue is a value parsed from a config file in any format. Objects keep
+// their keys in declared order, which endpoint matching depends on.
+type docValue struct {
+	kind docKind
+	// text is the contents of a string, or the JSON literal of a number or bool.
+	text   string
+	keys   []string
+	fields []*docValue
+	items  []*docValue
+	pos    position
+}
+
+func newObject(pos position) *docValue {
+	return &docValue{kind: docObject, pos: pos}
+}
+
+func (v *docValue) field(key string) *docValue {
+	for i, name := range v.keys {
+		if name == key {
+			return v.fields[i]
+		}
+	}
+	return nil
+}
+
+func (v *docValue) set(key string, value *docValue) {
+	v.keys = append(v.keys, key)
+	v.fields = append(v.fields, value)
+}
+
+// decode parses a config file, choosing the format by extension: .yaml and .yml
+// are YAML, .toml is TOML, and anything else is JSON5, a superset of JSON.
+func decode(path string, contents []byte) (Config, error) {
+	parse := parseJSON5
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parse = parseYAML
+	case ".toml":
+		parse = parseTOML
+	}
+
+	doc, err := parse(contents)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.File = path
+		}
+		return Config{}, err
+	}
+
+	encoder := &jsonEncoder{}
+	encoder.encode(doc)
+	var cfg Config
+	if err := json.Unmarshal(encoder.buf.Bytes(), &cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			pos := encoder.positionAt(int(typeErr.Offset))
+			return Config{}, &ParseError{File: path, Line: pos.line, Column: pos.column, Err: describeTypeError(typeErr)}
+		}
+		var valueErr *valueError
+		if errors.As(err, &valueErr) {
+			if offset, ok := encoder.offsetOf(valueErr.value); ok {
+				pos := encoder.positionAt(offset)
+				return Config{}, &ParseError{File: path, Line: pos.line, Column: pos.column, Err: valueErr.err}
+			}
+		}
+		return Config{}, fmt.Errorf("decoding config file %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// describeTypeError words a type mismatch in terms of the config file, without
+// the Go type encoding/json decoded it into.
+func describeTypeError(err *json.UnmarshalTypeError) error {
+	if err.Field == "" {
+		return fmt.Errorf("expected %s, got %s", err.Type, err.Value)
+	}
+	return fmt.Errorf("%s: expected %s, got %s", fieldPointerUnescaper.Replace(err.Field), err.Type, err.Value)
+}
+
+// fieldPointerUnescaper undoes the JSON pointer escaping encoding/json applies
+// to the keys in a field path, so endpoint keys read as they were written.
+var fieldPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// jsonEncoder writes a document as JSON, remembering where each value came from
+// so decoding errors can point into the original file.
+type jsonEncoder struct {
+	buf   bytes.Buffer
+	spans []valueSpan
+}
+
+type valueSpan struct {
+	start, end int
+	pos        position
+}
+
+func (e *jsonEncoder) encode(v *docValue) {
+	start := e.buf.Len()
+	switch v.kind {
+	case docNull:
+		e.buf.WriteString("null")
+	case docBool, docNumber:
+		e.buf.WriteString(v.text)
+	case docString:
+		quoted, _ := json.Marshal(v.text)
+		e.buf.Write(quoted)
+	case docObject:
+		e.buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			quoted, _ := json.Marshal(key)
+			e.buf.Write(quoted)
+			e.buf.WriteByte(':')
+			e.encode(v.fields[i])
+		}
+		e.buf.WriteByte('}')
+	case docArray:
+		e.buf.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			e.encode(item)
+		}
+		e.buf.WriteByte(']')
+	}
+	e.spans = append(e.spans, valueSpan{start: start, end: e.buf.Len(), pos: v.pos})
+}
+
+// offsetOf returns the offset just after the first value in the document encoded
+// as value, which is where encoding/json stops when it rejects that value.
+func (e *jsonEncoder) offsetOf(value []byte) (int, bool) {
+	best := valueSpan{start: -1}
+	for _, span := range e.spans {
+		if bytes.Equal(e.buf.Bytes()[span.start:span.end], value) && (best.start < 0 || span.start < best.start) {
+			best = span
+		}
+	}
+	return best.end, best.start >= 0
+}
+
+// positionAt returns the position of the value encoding/json was decoding when
+// it stopped at offset. That is just after a scalar, or just after the opening
+// bracket of an object or array.
+func (e *jsonEncoder) positionAt(offset int) position {
+	best := valueSpan{start: -1}
+	for _, span := range e.spans {
+		if span.end == offset && (best.start < 0 || span.start < best.start) {
+			best = span
+		}
+	}
+	for _, span := range e.spans {
+		if best.start < 0 && span.start == offset-1 {
+			best = span
+		}
+	}
+	if best.start < 0 {
+		for _, span := range e.spans {
+			if span.start <= offset && offset < span.end && span.start >= best.start {
+				best = span
+			}
+		}
+	}
+	return best.pos
+}
+
+// expandEnv replaces ${VAR} with the environment variable VAR and ${VAR:-default}
+// with default when VAR is unset or empty. $${ stands for a literal ${.
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var expanded strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			expanded.WriteString(s)
+			return expanded.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			expanded.WriteString(s[:start-1] + "${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		reference := s[start+2 : start+end]
+		name, fallback, hasFallback := strings.Cut(reference, ":-")
+		if !validEnvName(name) {
+			return "", fmt.Errorf("invalid variable reference ${%s}", reference)
+		}
+		value := os.Getenv(name)
+		if value == "" && hasFallback {
+			value = fallback
+		}
+		expanded.WriteString(s[:start] + value)
+		s = s[start+end+1:]
+	}
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveScalar types an unquoted YAML scalar, or a quoted JSON5 or TOML string
+// that referred to an environment variable, the way YAML 1.2's core schema
+// does. text has already been expanded, so ${ATTEMPTS} set to 3 is a number.
+func resolveScalar(text string, pos position) *docValue {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return &docValue{kind: docNull, pos: pos}
+	case "true", "True", "TRUE":
+		return &docValue{kind: docBool, text: "true", pos: pos}
+	case "false", "False", "FALSE":
+		return &docValue{kind: docBool, text: "false", pos: pos}
+	}
+	if first := strings.TrimLeft(text, "+-"); first != "" && (first[0] == '.' || (first[0] >= '0' && first[0] <= '9')) {
+		if number, ok := normalizeNumber(text); ok {
+			return &docValue{kind: docNumber, text: number, pos: pos}
+		}
+	}
+	return &docValue{kind: docString, text: text, pos: pos}
+}
+
+// expandString expands a quoted JSON5 or TOML string. A string that referred to
+// an environment variable is typed like an unquoted YAML scalar, so every format
+// can take a number or bool from the environment; "$${" alone does not count.
+func expandString(text string, pos position) (*docValue, error) {
+	expanded, err := expandEnv(text)
+	if err != nil {
+		return nil, errorAt(pos, "%v", err)
+	}
+	if !strings.Contains(strings.ReplaceAll(text, "$${", ""), "${") {
+		return &docValue{kind: docString, text: expanded, pos: pos}, nil
+	}
+	return resolveScalar(expanded, pos), nil
+}
+
+// normalizeNumber turns a number as written in a config file into JSON. Digits
+// may be separated by underscores, as in 600_000; hexadecimal, octal and binary
+// integers and a leading + are accepted too.
+func normalizeNumber(literal string) (string, bool) {
+	digits, sign := literal, ""
+	if strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		if digits[0] == '-' {
+			sign = "-"
+		}
+		digits = digits[1:]
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] == '_' && (i == 0 || i == len(digits)-1 || !isAlphanumeric(digits[i-1]) || !isAlphanumeric(digits[i+1])) {
+			return "", false
+		}
+	}
+	digits = strings.ReplaceAll(digits, "_", "")
+	if digits == "" {
+		return "", false
+	}
+
+	if len(digits) > 2 && digits[0] == '0' && strings.ContainsRune("xXoObB", rune(digits[1])) {
+		value, err := strconv.ParseUint(digits, 0, 64)
+		if err != nil {
+			return "", false
+		}
+		return sign + strconv.FormatUint(value, 10), true
+	}
+	if strings.Trim(digits, "0123456789") == "" {
+		trimmed := strings.TrimLeft(digits, "0")
+		if trimmed == "" {
+			trimmed = "0"
+		}
+		return sign + trimmed, true
+	}
+	if strings.Trim(digits, "0123456789.eE+-") != "" || !strings.ContainsAny(digits[:1], "0123456789.") {
+		return "", false
+	}
+	value, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return "", false
+	}
+	return sign + strconv.FormatFloat(value, 'g', -1, 64), true
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// scanner walks a config file byte by byte, tracking the line and column.
+type scanner struct {
+	src    []byte
+	off    int
+	line   int
+	column int
+}
+
+func newScanner(src []byte) *scanner {
+	return &scanner{src: src, line: 1, column: 1}
+}
+
+func (s *scanner) eof() bool {
+	return s.off >= len(s.src)
+}
+
+func (s *scanner) peek() byte {
+	return s.peekAt(0)
+}
+
+func (s *scanner) peekAt(n int) byte {
+	if s.off+n >= len(s.src) {
+		return 0
+	}
+	return s.src[s.off+n]
+}
+
+func (s *scanner) next() byte {
+	if s.eof() {
+		return 0
+	}
+	c := s.src[s.off]
+	s.off++
+	if c == '\n' {
+		s.line++
+		s.column = 1
+	} else if c&0xC0 != 0x80 {
+		// Continuation bytes of UTF-8 characters do not start a new column.
+		s.column++
+	}
+	return c
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(s.src[s.off:], []byte(prefix))
+}
+
+func (s *scanner) pos() position {
+	return position{line: s.line, column: s.column}
+}
+
+// readHex reads n hexadecimal digits as a code point.
+func (s *scanner) readHex(n int) (rune, error) {
+	pos := s.pos()
+	if s.off+n > len(s.src) {
+		return 0, errorAt(pos, "invalid escape sequence")
+	}
+	value, err := strconv.ParseUint(string(s.src[s.off:s.off+n]), 16, 32)
+	if err != nil {
+		return 0, errorAt(pos, "invalid escape sequence")
+	}
+	for i := 0; i < n; i++ {
+		s.next()
+	}
+	return rune(value), nil
+}
+
+// describe names a byte for error messages.
+func describe(c byte) string {
+	if c == 0 {
+		return "end of file"
+	}
+	if c == '\n' {
+		return "end of line"
+	}
+	return strconv.QuoteRune(rune(c))
+}
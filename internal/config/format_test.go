@@ -0,0 +1,234 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+const jsonConfig = `{
+  // Upstreams, in the order they are tried.
+  "services": [
+    "http://svc-a:8080",
+    { url: 'http://svc-b:8080', weight: 2 },
+  ],
+  "strategy": "WEIGHTED_ROUND_ROBIN",
+  /* The DEFAULT endpoint must come first. */
+  "endpoints": {
+    "DEFAULT": {
+      "expireTimeout": 600_000,
+      "cacheBehavior": "CACHE",
+    },
+    "/api/*": { "cacheBehavior": "PASSTHROUGH", "pool": "api" },
+    "~/v[0-9]+/.*": { "expireTimeout": 0x10 },
+  },
+  "pools": {
+    "api": { "services": ["http://api:8080"], "strategy": "LEAST_CONNECTIONS" },
+  },
+  "cache": { "store": "MEMORY", "maxEntries": 5_000 },
+}
+`
+
+const yamlConfig = `# Upstreams, in the order they are tried.
+---
+services:
+  - http://svc-a:8080
+  - url: 'http://svc-b:8080'
+    weight: 2
+strategy: WEIGHTED_ROUND_ROBIN
+endpoints:
+  DEFAULT:
+    expireTimeout: 600_000 # ten minutes
+    cacheBehavior: CACHE
+  /api/*: {cacheBehavior: PASSTHROUGH, pool: api}
+  "~/v[0-9]+/.*":
+    expireTimeout: 0x10
+pools:
+  api:
+    services: [http://api:8080]
+    strategy: LEAST_CONNECTIONS
+cache:
+  store: MEMORY
+  maxEntries: 5_000
+`
+
+const tomlConfig = `# Upstreams, in the order they are tried.
+strategy = "WEIGHTED_ROUND_ROBIN"
+services = [
+  "http://svc-a:8080",
+  { url = 'http://svc-b:8080', weight = 2 },
+]
+
+[endpoints.DEFAULT]
+expireTimeout = 600_000
+cacheBehavior = "CACHE"
+
+[endpoints."/api/*"]
+cacheBehavior = "PASSTHROUGH"
+pool = "api"
+
+[endpoints."~/v[0-9]+/.*"]
+expireTimeout = 0x10
+
+[pools.api]
+services = ["http://api:8080"]
+strategy = "LEAST_CONNECTIONS"
+
+[cache]
+store = "MEMORY"
+maxEntries = 5_000
+`
+
+func TestLoadFormatsAreEquivalent(t *testing.T) {
+	want, err := Load(writeConfig(t, "config.json5", jsonConfig))
+	if err != nil {
+		t.Fatalf("loading JSON5: %v", err)
+	}
+	if want.Endpoints[DefaultEndpointKey].ExpireTimeout != Milliseconds(600000) || want.Endpoints["~/v[0-9]+/.*"].ExpireTimeout != Milliseconds(16) {
+		t.Fatalf("expected numbers with separators and hex to decode, got %+v", want.Endpoints)
+	}
+	if want.Services[1] != (Service{URL: "http://svc-b:8080", Weight: 2}) || want.Cache.MaxEntries != 5000 {
+		t.Fatalf("unexpected config %+v", want)
+	}
+
+	for name, contents := range map[string]string{"config.json": jsonConfig, "config.yaml": yamlConfig, "config.toml": tomlConfig} {
+		got, err := Load(writeConfig(t, name, contents))
+		if err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected %s to load as\n%+v\ngot\n%+v", name, want, got)
+		}
+	}
+}
+
+func TestLoadYAMLBlockScalarsAndNesting(t *testing.T) {
+	doc, err := parseYAML([]byte(`literal: |
+  line one
+  line two
+
+folded: >-
+  one
+  two
+
+  three
+list:
+- a
+- - b
+  - c
+- {x: [1, 2], y: ~}
+empty:
+quoted: "tab\there # not a comment"
+`))
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	encoder := &jsonEncoder{}
+	encoder.encode(doc)
+	want := `{"literal":"line one\nline two\n","folded":"one two\nthree","list":["a",["b","c"],{"x":[1,2],"y":null}],"empty":null,"quoted":"tab\there # not a comment"}`
+	if got := encoder.buf.String(); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestLoadExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("DOORMAN_UPSTREAM", "http://svc-a:8080")
+	t.Setenv("DOORMAN_TTL", "30000")
+	t.Setenv("DOORMAN_EMPTY", "")
+
+	for name, contents := range map[string]string{
+		"config.json": `{
+			"services": ["${DOORMAN_UPSTREAM}", "${DOORMAN_EMPTY:-http://svc-b:8080}"],
+			"strategy": "${DOORMAN_STRATEGY:-ROUND_ROBIN}",
+			"endpoints": {"DEFAULT": {"cacheBehavior": "PASSTHROUGH"}},
+			"healthCheck": {"path": "/$${literal}"}
+		}`,
+		"config.yml": `
+services: [ "${DOORMAN_UPSTREAM}", "${DOORMAN_EMPTY:-http://svc-b:8080}" ]
+strategy: ${DOORMAN_STRATEGY:-ROUND_ROBIN}
+endpoints:
+  DEFAULT:
+    cacheBehavior: PASSTHROUGH
+    expireTimeout: ${DOORMAN_TTL}
+healthCheck:
+  path: /$${literal}
+`,
+	} {
+		cfg, err := Load(writeConfig(t, name, contents))
+		if err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		if want := []string{"http://svc-a:8080", "http://svc-b:8080"}; !reflect.DeepEqual(cfg.ServiceURLs(), want) {
+			t.Fatalf("%s: expected services %v, got %v", name, want, cfg.ServiceURLs())
+		}
+		if cfg.Strategy != StrategyRoundRobin {
+			t.Fatalf("%s: expected the default strategy, got %q", name, cfg.Strategy)
+		}
+		if cfg.HealthCheck.Path != "/${literal}" {
+			t.Fatalf("%s: expected $${ to stay literal, got %q", name, cfg.HealthCheck.Path)
+		}
+	}
+
+	t.Setenv("DOORMAN_IGNORE", "true")
+	for name, contents := range map[string]string{
+		"typed.json": `{"services": ["http://a"], "strategy": "ROUND_ROBIN", "endpoints": {"DEFAULT": {"cacheBehavior": "CACHE", "expireTimeout": "${DOORMAN_TTL}", "ignoreParameters": "${DOORMAN_IGNORE}"}}}`,
+		"typed.yaml": "services: [http://a]\nstrategy: ROUND_ROBIN\nendpoints:\n  DEFAULT:\n    cacheBehavior: CACHE\n    expireTimeout: ${DOORMAN_TTL}\n    ignoreParameters: ${DOORMAN_IGNORE}\n",
+		"typed.toml": "services = [\"http://a\"]\nstrategy = \"ROUND_ROBIN\"\n[endpoints.DEFAULT]\ncacheBehavior = \"CACHE\"\nexpireTimeout = \"${DOORMAN_TTL}\"\nignoreParameters = \"${DOORMAN_IGNORE}\"\n",
+	} {
+		cfg, err := Load(writeConfig(t, name, contents))
+		if err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		endpoint := cfg.Endpoints[DefaultEndpointKey]
+		if endpoint.ExpireTimeout != Milliseconds(30000) || endpoint.IgnoreParameters == nil || !*endpoint.IgnoreParameters {
+			t.Fatalf("%s: expected expanded values to be typed, got %+v", name, endpoint)
+		}
+	}
+}
+
+func TestLoadReportsErrorPositions(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"config.json", "{\n  \"services\": [\"http://a\"],\n  \"strategy\": ROUND_ROBIN\n}", ":3:15: "},
+		{"config.json", "{\n  \"services\": [\"http://a\"]\n  \"strategy\": \"ROUND_ROBIN\"\n}", ":3:3: "},
+		{"config.json", "{\n  \"cache\": {\"maxEntries\": \"lots\"}\n}", ":2:27: cache.maxEntries: expected int, got string"},
+		{"config.json", "{\n  \"endpoints\": {\"/blog/~draft\": {\"ignoreParameters\": \"yes\"}}\n}", ":2:54: endpoints./blog/~draft.ignoreParameters: expected bool, got string"},
+		{"config.json", "{\"services\": [\"${BROKEN\"]}", ":1:15: "},
+		{"config.json", "{\n  \"services\": [\"http://a\", {\"url\": \"http://b\", \"weight\": 0}]\n}", ":2:28: weight must be at least 1"},
+		{"config.json", "{\n  \"endpoints\": {\n    \"DEFAULT\": {\"expireTimeout\": \"abc\"}\n  }\n}", ":3:34: invalid duration"},
+		{"config.yaml", "endpoints:\n  DEFAULT:\n    lockTTL: [1]\n", ":3:14: invalid duration"},
+		{"config.yaml", "endpoints:\n  DEFAULT:\n    expireTimeout: abc\n", ":3:20: invalid duration"},
+		{"config.yaml", "services:\n  - http://a\n strategy: ROUND_ROBIN\n", ":2: "},
+		{"config.yaml", "cache:\n  maxEntries: [1, 2]\n", ":2:15: "},
+		{"config.yaml", "a: 1\na: 2\n", ":2:1: "},
+		{"config.toml", "strategy = \"ROUND_ROBIN\"\n[cache]\nmaxEntries = 1979-05-27\n", ":3:14: "},
+		{"config.toml", "[cache]\nstore = \"MEMORY\"\n[cache]\n", ":3:1: "},
+	}
+
+	for _, test := range tests {
+		path := writeConfig(t, test.name, test.contents)
+		_, err := Load(path)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%q: expected a ParseError, got %v", test.contents, err)
+		}
+		if !strings.HasPrefix(err.Error(), path+test.want) {
+			t.Fatalf("%q: expected an error at %s, got %v", test.contents, test.want, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// HealthCheckConfig configures active probes of the upstream services. Probes are
+// enabled by setting path; services overrides individual fields per service URL.
+type HealthCheckConfig struct {
+	Path               string   `json:"path,omitempty"`
+	Interval           Duration `json:"interval,omitempty"`
+	Timeout            Duration `json:"timeout,omitempty"`
+	HealthyThreshold   int      `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold,omitempty"`
+	ExpectedStatus     int      `json:"expectedStatus,omitempty"`
+
+	Services map[string]HealthCheckConfig `json:"services,omitempty"`
+}
+
+func (h HealthCheckConfig) Enabled() bool {
+	return h.Path != ""
+}
+
+// For resolves the probe of one service, applying its override on top of the defaults.
+func (h HealthCheckConfig) For(serviceURL string) HealthCheckConfig {
+	merged := h
+	merged.Services = nil
+
+	override, ok := h.Services[serviceURL]
+	if !ok {
+		return merged
+	}
+	if override.Path != "" {
+		merged.Path = override.Path
+	}
+	if override.Interval.IsSet() {
+		merged.Interval = override.Interval
+	}
+	if override.Timeout.IsSet() {
+		merged.Timeout = override.Timeout
+	}
+	if override.HealthyThreshold > 0 {
+		merged.HealthyThreshold = override.HealthyThreshold
+	}
+	if override.UnhealthyThreshold > 0 {
+		merged.UnhealthyThreshold = override.UnhealthyThreshold
+	}
+	if override.ExpectedStatus > 0 {
+		merged.ExpectedStatus = override.ExpectedStatus
+	}
+	return merged
+}
+
+func (h HealthCheckConfig) IntervalDuration() time.Duration {
+	return h.Interval.Positive(defaultHealthCheckInterval)
+}
+
+func (h HealthCheckConfig) TimeoutDuration() time.Duration {
+	return h.Timeout.Positive(defaultHealthCheckTimeout)
+}
+
+func (h HealthCheckConfig) HealthyAfter() int {
+	if h.HealthyThreshold <= 0 {
+		return defaultHealthyThreshold
+	}
+	return h.HealthyThreshold
+}
+
+func (h HealthCheckConfig) UnhealthyAfter() int {
+	if h.UnhealthyThreshold <= 0 {
+		return defaultUnhealthyThreshold
+	}
+	return h.UnhealthyThreshold
+}
+
+// Status is the response status a healthy service answers the probe with.
+func (h HealthCheckConfig) Status() int {
+	if h.ExpectedStatus <= 0 {
+		return http.StatusOK
+	}
+	return h.ExpectedStatus
+}
+
+func (h HealthCheckConfig) validate(services []string) error {
+	if err := h.validateProbe(); err != nil {
+		return err
+	}
+
+	for serviceURL, override := range h.Services {
+		known := false
+		for _, candidate := range services {
+			if candidate == serviceURL {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("services.%s does not name a configured service", serviceURL)
+		}
+		if len(override.Services) > 0 {
+			return fmt.Errorf("services.%s cannot contain services", serviceURL)
+		}
+		if err := h.For(serviceURL).validateProbe(); err != nil {
+			return fmt.Errorf("services.%s: %w", serviceURL, err)
+		}
+	}
+
+	return nil
+}
+
+func (h HealthCheckConfig) validateProbe() error {
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("path %q must start with /", h.Path)
+	}
+	if h.Interval.Duration() < 0 {
+		return errors.New("interval must be >= 0")
+	}
+	if h.Timeout.Duration() < 0 {
+		return errors.New("timeout must be >= 0")
+	}
+	if h.HealthyThreshold < 0 {
+		return errors.New("healthyThreshold must be >= 0")
+	}
+	if h.UnhealthyThreshold < 0 {
+		return errors.New("unhealthyThreshold must be >= 0")
+	}
+	if h.ExpectedStatus != 0 && (h.ExpectedStatus < 100 || h.ExpectedStatus > 599) {
+		return fmt.Errorf("expectedStatus %d is not an HTTP status code", h.ExpectedStatus)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+const hostPoolPrefix = "host:"
+
+// HostConfig serves one site, matched by the request Host header. Its services
+// and strategy form the host's own pool, defaulting to the top-level ones, and
+// its endpoints inherit from its DEFAULT, which inherits from the top-level
+// DEFAULT.
+type HostConfig struct {
+	Services  []Service                 `json:"services,omitempty"`
+	Strategy  string                    `json:"strategy,omitempty"`
+	Endpoints map[string]EndpointConfig `json:"endpoints,omitempty"`
+
+	endpointOrder []string
+}
+
+func (h *HostConfig) UnmarshalJSON(data []byte) error {
+	type plain HostConfig
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Endpoints json.RawMessage `json:"endpoints"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	decoded.endpointOrder = declaredOrder(decoded.Endpoints, objectKeys(raw.Endpoints))
+
+	*h = HostConfig(decoded)
+	return nil
+}
+
+// NormalizeHost lowercases a Host header and strips its port and trailing dot.
+func NormalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// MatchHost finds the hosts entry for a Host header: an exact match first, then
+// the longest matching wildcard such as *.example.com. It returns the entry's
+// key, or false if the request falls through to the top-level configuration.
+func (c Config) MatchHost(host string) (string, bool) {
+	host = NormalizeHost(host)
+	if _, ok := c.Hosts[host]; ok {
+		return host, true
+	}
+
+	matched := ""
+	for pattern := range c.Hosts {
+		suffix, ok := strings.CutPrefix(pattern, "*")
+		if ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) && len(pattern) > len(matched) {
+			matched = pattern
+		}
+	}
+	return matched, matched != ""
+}
+
+// EndpointFor resolves the endpoint configuration of a request by Host header
+// and path. Requests for a host with its own services use the host's pool unless
+// the endpoint names another.
+func (c Config) EndpointFor(host, path string) EndpointConfig {
+	pattern, ok := c.MatchHost(host)
+	if !ok {
+		return c.Endpoint(path)
+	}
+	return c.hostEndpoint(pattern, path)
+}
+
+// MatchEndpoint returns the virtual host pattern ("" for none) and endpoint key
+// a request for host and path resolves through, or DEFAULT if no key matches.
+func (c Config) MatchEndpoint(host, path string) (hostPattern, key string) {
+	endpoints, order := c.Endpoints, c.endpointOrder
+	hostPattern, ok := c.MatchHost(host)
+	if ok {
+		endpoints, order = c.Hosts[hostPattern].Endpoints, c.Hosts[hostPattern].endpointOrder
+	}
+	if key, ok = matchEndpoint(endpoints, order, path); !ok {
+		key = DefaultEndpointKey
+	}
+	return hostPattern, key
+}
+
+func (c Config) hostEndpoint(pattern, path string) EndpointConfig {
+	hostCfg := c.Hosts[pattern]
+	key, _ := matchEndpoint(hostCfg.Endpoints, hostCfg.endpointOrder, path)
+	return c.hostEndpointByKey(pattern, key)
+}
+
+func (c Config) hostEndpointByKey(pattern, key string) EndpointConfig {
+	hostCfg := c.Hosts[pattern]
+	hostLevel := hostCfg.Endpoints[DefaultEndpointKey]
+	if override, ok := hostCfg.Endpoints[key]; ok && key != "" {
+		hostLevel = hostLevel.withOverride(override)
+	}
+
+	resolved := c.Endpoints[DefaultEndpointKey].withOverride(hostLevel)
+	if len(hostCfg.Services) > 0 && hostLevel.Pool == "" {
+		resolved.Pool = HostPoolKey(pattern)
+	}
+	return resolved
+}
+
+// HostPoolKey names the pool formed by a host's own services.
+func HostPoolKey(pattern string) string {
+	return hostPoolPrefix + pattern
+}
+
+// UsesHosts reports whether requests are told apart by Host header, in which case
+// the host is part of every cache key.
+func (c Config) UsesHosts() bool {
+	return len(c.Hosts) > 0
+}
+
+func (c Config) hostPool(name string) (PoolConfig, bool) {
+	pattern, ok := strings.CutPrefix(name, hostPoolPrefix)
+	if !ok {
+		return PoolConfig{}, false
+	}
+	hostCfg, ok := c.Hosts[pattern]
+	if !ok || len(hostCfg.Services) == 0 {
+		return PoolConfig{}, false
+	}
+	pool := PoolConfig{Services: hostCfg.Services, Strategy: hostCfg.Strategy}
+	if pool.Strategy == "" {
+		pool.Strategy = c.Strategy
+	}
+	return pool, true
+}
+
+func (c Config) hostPoolNames() []string {
+	var names []string
+	for pattern, hostCfg := range c.Hosts {
+		if len(hostCfg.Services) > 0 {
+			names = append(names, HostPoolKey(pattern))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c Config) validateHosts() error {
+	for pattern, hostCfg := range c.Hosts {
+		if err := validateHostPattern(pattern); err != nil {
+			return err
+		}
+		if len(hostCfg.Services) > 0 {
+			pool, _ := c.hostPool(HostPoolKey(pattern))
+			if err := pool.validate(); err != nil {
+				return fmt.Errorf("invalid hosts.%s: %w", pattern, err)
+			}
+		} else if hostCfg.Strategy != "" {
+			return fmt.Errorf("invalid hosts.%s: strategy requires services", pattern)
+		}
+
+		if err := validateEndpointPatterns(hostCfg.Endpoints); err != nil {
+			return fmt.Errorf("invalid hosts.%s: %w", pattern, err)
+		}
+		for endpoint, endpointCfg := range hostCfg.Endpoints {
+			if err := validateEndpointKey(endpoint); err != nil {
+				return fmt.Errorf("invalid hosts.%s: %w", pattern, err)
+			}
+			if err := c.validateEndpoint(endpointCfg, false); err != nil {
+				return fmt.Errorf("invalid hosts.%s.endpoints.%s: %w", pattern, endpoint, err)
+			}
+			resolved := c.hostEndpointByKey(pattern, endpoint)
+			if resolved.CacheBehavior == CacheBehaviorCache && resolved.CacheTTL() <= 0 {
+				return fmt.Errorf("hosts.%s.endpoints.%s resolves to CACHE but has no positive expireTimeout", pattern, endpoint)
+			}
+		}
+	}
+	return nil
+}
+
+func validateHostPattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("host keys cannot be empty")
+	}
+	if pattern != NormalizeHost(pattern) {
+		return fmt.Errorf("host %q must be a lowercase hostname without port", pattern)
+	}
+	if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+		return fmt.Errorf("host %q may only use a leading *. wildcard", pattern)
+	}
+	return nil
+}
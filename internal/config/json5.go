@@ -0,0 +1,279 @@
+package config
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// parseJSON5 parses JSON extended with // and /* */ comments, trailing commas,
+// unquoted and single-quoted keys, single-quoted strings and numbers with
+// underscores, which covers JSONC and the parts of JSON5 a config file needs.
+// Like TOML, it is parsed here because JSON5 libraries are forks of
+// encoding/json that decode into Go values without keeping positions.
+func parseJSON5(contents []byte) (*docValue, error) {
+	p := &json5Parser{scanner: newScanner(contents)}
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+	if p.eof() {
+		return nil, errorAt(p.pos(), "empty config file")
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, errorAt(p.pos(), "unexpected %s after the end of the document", describe(p.peek()))
+	}
+	return value, nil
+}
+
+type json5Parser struct {
+	*scanner
+}
+
+func (p *json5Parser) skipSpace() error {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.next()
+		case p.hasPrefix("//"):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case p.hasPrefix("/*"):
+			start := p.pos()
+			p.next()
+			p.next()
+			for !p.hasPrefix("*/") {
+				if p.eof() {
+					return errorAt(start, "unterminated comment")
+				}
+				p.next()
+			}
+			p.next()
+			p.next()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *json5Parser) value() (*docValue, error) {
+	pos := p.pos()
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"' || c == '\'':
+		text, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return expandString(text, pos)
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		literal := p.numberLiteral()
+		number, ok := normalizeNumber(literal)
+		if !ok {
+			return nil, errorAt(pos, "invalid number %q", literal)
+		}
+		return &docValue{kind: docNumber, text: number, pos: pos}, nil
+	case isIdentifierByte(c):
+		switch word := p.identifier(); word {
+		case "true", "false":
+			return &docValue{kind: docBool, text: word, pos: pos}, nil
+		case "null":
+			return &docValue{kind: docNull, pos: pos}, nil
+		default:
+			return nil, errorAt(pos, "unexpected %q, strings must be quoted", word)
+		}
+	default:
+		return nil, errorAt(pos, "unexpected %s", describe(c))
+	}
+}
+
+func (p *json5Parser) object() (*docValue, error) {
+	object := newObject(p.pos())
+	p.next()
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.peek() == '}' {
+			p.next()
+			return object, nil
+		}
+
+		keyPos := p.pos()
+		var key string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			var err error
+			if key, err = p.quoted(); err != nil {
+				return nil, err
+			}
+		case isIdentifierByte(c):
+			key = p.identifier()
+		default:
+			return nil, errorAt(keyPos, "expected a key or '}', found %s", describe(c))
+		}
+		if object.field(key) != nil {
+			return nil, errorAt(keyPos, "duplicate key %q", key)
+		}
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.peek() != ':' {
+			return nil, errorAt(p.pos(), "expected ':' after key %q, found %s", key, describe(p.peek()))
+		}
+		p.next()
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		object.set(key, value)
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		switch p.peek() {
+		case ',':
+			p.next()
+		case '}':
+		default:
+			return nil, errorAt(p.pos(), "expected ',' or '}', found %s", describe(p.peek()))
+		}
+	}
+}
+
+func (p *json5Parser) array() (*docValue, error) {
+	array := &docValue{kind: docArray, pos: p.pos()}
+	p.next()
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ']' {
+			p.next()
+			return array, nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		array.items = append(array.items, item)
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		switch p.peek() {
+		case ',':
+			p.next()
+		case ']':
+		default:
+			return nil, errorAt(p.pos(), "expected ',' or ']', found %s", describe(p.peek()))
+		}
+	}
+}
+
+// quoted reads a single- or double-quoted string with JSON5 escapes.
+func (p *json5Parser) quoted() (string, error) {
+	start := p.pos()
+	quote := p.next()
+	var text strings.Builder
+	for {
+		c := p.peek()
+		switch {
+		case p.eof() || c == '\n':
+			return "", errorAt(start, "unterminated string")
+		case c == quote:
+			p.next()
+			return text.String(), nil
+		case c != '\\':
+			text.WriteByte(p.next())
+			continue
+		}
+
+		escapePos := p.pos()
+		p.next()
+		switch escaped := p.next(); escaped {
+		case 'b':
+			text.WriteByte('\b')
+		case 'f':
+			text.WriteByte('\f')
+		case 'n':
+			text.WriteByte('\n')
+		case 'r':
+			text.WriteByte('\r')
+		case 't':
+			text.WriteByte('\t')
+		case 'v':
+			text.WriteByte('\v')
+		case '0':
+			text.WriteByte(0)
+		case '\n':
+			// A backslash before a line break continues the string.
+		case '\r':
+			if p.peek() == '\n' {
+				p.next()
+			}
+		case 'u':
+			r, err := p.readHex(4)
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) && p.hasPrefix(`\u`) {
+				p.next()
+				p.next()
+				low, err := p.readHex(4)
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, low)
+			}
+			if r == utf8.RuneError || utf16.IsSurrogate(r) {
+				return "", errorAt(escapePos, "invalid unicode escape")
+			}
+			text.WriteRune(r)
+		case '"', '\'', '\\', '/':
+			text.WriteByte(escaped)
+		default:
+			return "", errorAt(escapePos, "invalid escape sequence \\%c", escaped)
+		}
+	}
+}
+
+func (p *json5Parser) numberLiteral() string {
+	start := p.off
+	for !p.eof() {
+		c := p.peek()
+		sign := (c == '+' || c == '-') && (p.off == start || p.src[p.off-1] == 'e' || p.src[p.off-1] == 'E')
+		if !sign && !isAlphanumeric(c) && c != '_' && c != '.' {
+			break
+		}
+		p.next()
+	}
+	return string(p.src[start:p.off])
+}
+
+func (p *json5Parser) identifier() string {
+	start := p.off
+	for !p.eof() && (isIdentifierByte(p.peek()) || (p.peek() >= '0' && p.peek() <= '9')) {
+		p.next()
+	}
+	return string(p.src[start:p.off])
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
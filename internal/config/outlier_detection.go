@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 5 * time.Minute
+	defaultMaxEjectionPercent = 50
+)
+
+// OutlierDetectionConfig ejects services that keep failing real traffic. It is
+// enabled by setting consecutiveFailures.
+type OutlierDetectionConfig struct {
+	ConsecutiveFailures int      `json:"consecutiveFailures,omitempty"`
+	BaseEjectionTime    Duration `json:"baseEjectionTime,omitempty"`
+	MaxEjectionTime     Duration `json:"maxEjectionTime,omitempty"`
+	MaxEjectionPercent  *int     `json:"maxEjectionPercent,omitempty"`
+}
+
+func (o OutlierDetectionConfig) Enabled() bool {
+	return o.ConsecutiveFailures > 0
+}
+
+func (o OutlierDetectionConfig) BaseEjectionDuration() time.Duration {
+	return o.BaseEjectionTime.Positive(defaultBaseEjectionTime)
+}
+
+func (o OutlierDetectionConfig) MaxEjectionDuration() time.Duration {
+	return o.MaxEjectionTime.Positive(max(defaultMaxEjectionTime, o.BaseEjectionDuration()))
+}
+
+// EjectablePercent is the largest share of the services that may be ejected at once.
+func (o OutlierDetectionConfig) EjectablePercent() int {
+	if o.MaxEjectionPercent == nil {
+		return defaultMaxEjectionPercent
+	}
+	return *o.MaxEjectionPercent
+}
+
+func (o OutlierDetectionConfig) validate() error {
+	if o.ConsecutiveFailures < 0 {
+		return errors.New("consecutiveFailures must be >= 0")
+	}
+	if o.BaseEjectionTime.Duration() < 0 {
+		return errors.New("baseEjectionTime must be >= 0")
+	}
+	if o.MaxEjectionTime.Duration() < 0 {
+		return errors.New("maxEjectionTime must be >= 0")
+	}
+	if o.MaxEjectionTime.Duration() > 0 && o.MaxEjectionTime.Duration() < o.BaseEjectionTime.Duration() {
+		return errors.New("maxEjectionTime must be >= baseEjectionTime")
+	}
+	if percent := o.EjectablePercent(); percent < 0 || percent > 100 {
+		return errors.New("maxEjectionPercent must be between 0 and 100")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultPoolKey names the pool formed by the top-level services and strategy.
+const DefaultPoolKey = "DEFAULT"
+
+// PoolConfig is a named group of upstream services with its own strategy, which
+// endpoints select with pool.
+type PoolConfig struct {
+	Services []Service `json:"services"`
+	Strategy string    `json:"strategy"`
+}
+
+// Pool resolves a pool by name; an empty name or DEFAULT is the top-level pool.
+func (c Config) Pool(name string) PoolConfig {
+	if name == "" || name == DefaultPoolKey {
+		return PoolConfig{Services: c.Services, Strategy: c.Strategy}
+	}
+	if pool, ok := c.hostPool(name); ok {
+		return pool
+	}
+	return c.Pools[name]
+}
+
+// PoolNames lists DEFAULT, the named pools and the pools of hosts with their
+// own services, in order.
+func (c Config) PoolNames() []string {
+	names := make([]string, 0, len(c.Pools))
+	for name := range c.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(append([]string{DefaultPoolKey}, names...), c.hostPoolNames()...)
+}
+
+// ServiceURLs lists the URL of every service in the pool.
+func (p PoolConfig) ServiceURLs() []string {
+	urls := make([]string, 0, len(p.Services))
+	for _, svc := range p.Services {
+		urls = append(urls, svc.URL)
+	}
+	return urls
+}
+
+// AllServiceURLs lists the URL of every service across all pools, once each.
+func (c Config) AllServiceURLs() []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, name := range c.PoolNames() {
+		for _, serviceURL := range c.Pool(name).ServiceURLs() {
+			if !seen[serviceURL] {
+				seen[serviceURL] = true
+				urls = append(urls, serviceURL)
+			}
+		}
+	}
+	return urls
+}
+
+func (p PoolConfig) validate() error {
+	if len(p.Services) == 0 {
+		return errors.New("services must contain at least one upstream")
+	}
+
+	for i, svc := range p.Services {
+		if strings.TrimSpace(svc.URL) == "" {
+			return fmt.Errorf("services[%d] cannot be empty", i)
+		}
+		if svc.Weight < 0 {
+			return fmt.Errorf("services[%d].weight must be >= 1", i)
+		}
+	}
+
+	if p.Strategy == "" {
+		return errors.New("strategy is required")
+	}
+
+	switch p.Strategy {
+	case StrategyRoundRobin, StrategyLeastConnections, StrategyWeightedRoundRobin, StrategyP2C, StrategyPeakEWMA, StrategyConsistentHash:
+	default:
+		return fmt.Errorf("unsupported strategy %q", p.Strategy)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	defaultRetryBudgetPercent  = 20
+	defaultMinRetryConcurrency = 3
+)
+
+var defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryConfig retries failed upstream requests on other services. Retries are
+// enabled by setting maxAttempts above 1.
+type RetryConfig struct {
+	MaxAttempts         int      `json:"maxAttempts,omitempty"`
+	RetryOnConnectError *bool    `json:"retryOnConnectError,omitempty"`
+	RetryStatusCodes    []int    `json:"retryStatusCodes,omitempty"`
+	PerTryTimeout       Duration `json:"perTryTimeout,omitempty"`
+
+	// BudgetPercent caps requests retrying at once to a share of the active
+	// upstream requests, but always allows MinRetryConcurrency.
+	BudgetPercent       *int `json:"budgetPercent,omitempty"`
+	MinRetryConcurrency *int `json:"minRetryConcurrency,omitempty"`
+}
+
+func (r RetryConfig) Enabled() bool {
+	return r.MaxAttempts > 1
+}
+
+// Attempts is the most tries, including the first, made for one request.
+func (r RetryConfig) Attempts() int {
+	return max(r.MaxAttempts, 1)
+}
+
+func (r RetryConfig) RetriesConnectErrors() bool {
+	return r.RetryOnConnectError == nil || *r.RetryOnConnectError
+}
+
+func (r RetryConfig) RetriesStatus(statusCode int) bool {
+	codes := r.RetryStatusCodes
+	if codes == nil {
+		codes = defaultRetryStatusCodes
+	}
+	return slices.Contains(codes, statusCode)
+}
+
+func (r RetryConfig) PerTryTimeoutDuration() time.Duration {
+	return r.PerTryTimeout.Duration()
+}
+
+func (r RetryConfig) Budget() int {
+	if r.BudgetPercent == nil {
+		return defaultRetryBudgetPercent
+	}
+	return *r.BudgetPercent
+}
+
+func (r RetryConfig) MinConcurrency() int {
+	if r.MinRetryConcurrency == nil {
+		return defaultMinRetryConcurrency
+	}
+	return *r.MinRetryConcurrency
+}
+
+func (r RetryConfig) validate() error {
+	if r.MaxAttempts < 0 {
+		return errors.New("maxAttempts must be >= 0")
+	}
+	for i, code := range r.RetryStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("retryStatusCodes[%d] %d is not an HTTP status code", i, code)
+		}
+	}
+	if r.PerTryTimeout.Duration() < 0 {
+		return errors.New("perTryTimeout must be >= 0")
+	}
+	if budget := r.Budget(); budget < 0 || budget > 100 {
+		return errors.New("budgetPercent must be between 0 and 100")
+	}
+	if r.MinConcurrency() < 0 {
+		return errors.New("minRetryConcurrency must be >= 0")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+const defaultServiceWeight = 1
+
+// Service is an upstream service. In the config file it is either the service
+// URL or an object with url and weight; weight defaults to 1, must be at least 1
+// when given, and only affects the weighted strategies.
+type Service struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+func (s *Service) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*s = Service{}
+		return json.Unmarshal(data, &s.URL)
+	}
+
+	type plain Service
+	var decoded struct {
+		plain
+		Weight *int `json:"weight"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	// A weight of 0 would look like a drained service but still take traffic.
+	if decoded.Weight != nil && *decoded.Weight < 1 {
+		return newValueError(data, errors.New("weight must be at least 1; remove the service to stop sending it traffic"))
+	}
+	*s = Service(decoded.plain)
+	if decoded.Weight != nil {
+		s.Weight = *decoded.Weight
+	}
+	return nil
+}
+
+func (s Service) EffectiveWeight() int {
+	if s.Weight <= 0 {
+		return defaultServiceWeight
+	}
+	return s.Weight
+}
+
+// ServiceURLs lists the URL of every service in the DEFAULT pool.
+func (c Config) ServiceURLs() []string {
+	return c.Pool(DefaultPoolKey).ServiceURLs()
+}
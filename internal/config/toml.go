@@ -0,0 +1,411 @@
+package config
+
+import (
+	"strings"
+)
+
+// parseTOML parses TOML: key/value pairs with bare, quoted and dotted keys,
+// [tables] and [[arrays of tables]], strings in all four forms, numbers,
+// booleans, arrays and inline tables. Dates and times are not supported.
+//
+// TOML is parsed here rather than with a library because the common ones decode
+// straight into Go values: github.com/BurntSushi/toml keeps key order in its
+// MetaData but not where each value was, so a bad duration or a misspelt
+// cacheBehavior could not be reported with its line and column.
+func parseTOML(contents []byte) (*docValue, error) {
+	p := &tomlParser{scanner: newScanner(contents), explicit: map[*docValue]bool{}}
+	root := newObject(p.pos())
+	current := root
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		var err error
+		if p.peek() == '[' {
+			current, err = p.table(root)
+		} else {
+			err = p.keyValue(current)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	*scanner
+	// explicit holds the tables defined by a [header], which may not repeat.
+	explicit map[*docValue]bool
+	// tableArrays holds the arrays created by [[headers]].
+	tableArrays map[*docValue]bool
+}
+
+// skipSpace skips blanks and comments, and line breaks too if newlines is set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.next()
+		case c == '\n' && newlines:
+			p.next()
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+	if !p.eof() && p.peek() != '\n' {
+		return errorAt(p.pos(), "expected the end of the line, found %s", describe(p.peek()))
+	}
+	return nil
+}
+
+// table parses a [table] or [[array of tables]] header and returns the table
+// that the following keys go into.
+func (p *tomlParser) table(root *docValue) (*docValue, error) {
+	pos := p.pos()
+	p.next()
+	isArray := p.peek() == '['
+	if isArray {
+		p.next()
+	}
+	p.skipSpace(false)
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(false)
+	closing := "]"
+	if isArray {
+		closing = "]]"
+	}
+	if !p.hasPrefix(closing) {
+		return nil, errorAt(p.pos(), "expected %q, found %s", closing, describe(p.peek()))
+	}
+	for range closing {
+		p.next()
+	}
+
+	parent, err := p.descend(root, keys[:len(keys)-1], pos)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	existing := parent.field(last)
+
+	if isArray {
+		if existing == nil {
+			existing = &docValue{kind: docArray, pos: pos}
+			parent.set(last, existing)
+			if p.tableArrays == nil {
+				p.tableArrays = map[*docValue]bool{}
+			}
+			p.tableArrays[existing] = true
+		} else if !p.tableArrays[existing] {
+			return nil, errorAt(pos, "key %q is already defined", last)
+		}
+		table := newObject(pos)
+		existing.items = append(existing.items, table)
+		return table, nil
+	}
+
+	switch {
+	case existing == nil:
+		existing = newObject(pos)
+		parent.set(last, existing)
+	case existing.kind != docObject || p.explicit[existing]:
+		return nil, errorAt(pos, "table %q is already defined", strings.Join(keys, "."))
+	}
+	p.explicit[existing] = true
+	return existing, nil
+}
+
+// descend walks the dotted keys from table, creating the tables that do not
+// exist yet. A key naming an array of tables continues in its last table.
+func (p *tomlParser) descend(table *docValue, keys []string, pos position) (*docValue, error) {
+	for _, key := range keys {
+		next := table.field(key)
+		switch {
+		case next == nil:
+			next = newObject(pos)
+			table.set(key, next)
+		case p.tableArrays[next]:
+			next = next.items[len(next.items)-1]
+		case next.kind != docObject:
+			return nil, errorAt(pos, "key %q is already defined as a value", key)
+		}
+		table = next
+	}
+	return table, nil
+}
+
+func (p *tomlParser) keyValue(table *docValue) error {
+	pos := p.pos()
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace(false)
+	if p.peek() != '=' {
+		return errorAt(p.pos(), "expected '=' after key %q, found %s", strings.Join(keys, "."), describe(p.peek()))
+	}
+	p.next()
+	p.skipSpace(false)
+
+	parent, err := p.descend(table, keys[:len(keys)-1], pos)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if parent.field(last) != nil {
+		return errorAt(pos, "duplicate key %q", strings.Join(keys, "."))
+	}
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent.set(last, value)
+	return nil
+}
+
+// key parses a possibly dotted key into its parts.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		pos := p.pos()
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			key, err := p.singleLineString()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case isBareKeyByte(c):
+			start := p.off
+			for !p.eof() && isBareKeyByte(p.peek()) {
+				p.next()
+			}
+			keys = append(keys, string(p.src[start:p.off]))
+		default:
+			return nil, errorAt(pos, "expected a key, found %s", describe(c))
+		}
+		p.skipSpace(false)
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.next()
+	}
+}
+
+func (p *tomlParser) value() (*docValue, error) {
+	pos := p.pos()
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		var text string
+		var err error
+		if p.hasPrefix(`"""`) || p.hasPrefix("'''") {
+			text, err = p.multiLineString()
+		} else {
+			text, err = p.singleLineString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return expandString(text, pos)
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	}
+
+	start := p.off
+	for !p.eof() && (isBareKeyByte(p.peek()) || strings.IndexByte("+.:", p.peek()) >= 0) {
+		p.next()
+	}
+	literal := string(p.src[start:p.off])
+	switch {
+	case literal == "true" || literal == "false":
+		return &docValue{kind: docBool, text: literal, pos: pos}, nil
+	case literal == "":
+		return nil, errorAt(pos, "expected a value, found %s", describe(p.peek()))
+	case strings.Contains(literal, ":") || (len(literal) > 4 && literal[4] == '-' && strings.Trim(literal[:4], "0123456789") == ""):
+		return nil, errorAt(pos, "dates and times are not supported")
+	}
+	number, ok := normalizeNumber(literal)
+	if !ok {
+		return nil, errorAt(pos, "invalid value %q", literal)
+	}
+	return &docValue{kind: docNumber, text: number, pos: pos}, nil
+}
+
+func (p *tomlParser) array() (*docValue, error) {
+	array := &docValue{kind: docArray, pos: p.pos()}
+	p.next()
+	for {
+		p.skipSpace(true)
+		if p.peek() == ']' {
+			p.next()
+			return array, nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		array.items = append(array.items, item)
+
+		p.skipSpace(true)
+		switch p.peek() {
+		case ',':
+			p.next()
+		case ']':
+		default:
+			return nil, errorAt(p.pos(), "expected ',' or ']', found %s", describe(p.peek()))
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (*docValue, error) {
+	table := newObject(p.pos())
+	p.next()
+	for {
+		p.skipSpace(false)
+		if p.peek() == '}' {
+			p.next()
+			return table, nil
+		}
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace(false)
+		switch p.peek() {
+		case ',':
+			p.next()
+		case '}':
+		default:
+			return nil, errorAt(p.pos(), "expected ',' or '}', found %s", describe(p.peek()))
+		}
+	}
+}
+
+// singleLineString reads a basic ("...") or literal ('...') string.
+func (p *tomlParser) singleLineString() (string, error) {
+	start := p.pos()
+	quote := p.next()
+	var text strings.Builder
+	for {
+		c := p.peek()
+		switch {
+		case p.eof() || c == '\n':
+			return "", errorAt(start, "unterminated string")
+		case c == quote:
+			p.next()
+			return text.String(), nil
+		case c == '\\' && quote == '"':
+			if err := p.escape(&text); err != nil {
+				return "", err
+			}
+		default:
+			text.WriteByte(p.next())
+		}
+	}
+}
+
+// multiLineString reads a multi-line basic or literal string, delimited by three
+// double or single quotes. A line break right after the opening quotes is dropped.
+func (p *tomlParser) multiLineString() (string, error) {
+	start := p.pos()
+	quote := p.peek()
+	delimiter := strings.Repeat(string(quote), 3)
+	for range delimiter {
+		p.next()
+	}
+	if p.hasPrefix("\r\n") {
+		p.next()
+	}
+	if p.peek() == '\n' {
+		p.next()
+	}
+
+	var text strings.Builder
+	for {
+		switch {
+		case p.eof():
+			return "", errorAt(start, "unterminated string")
+		case p.hasPrefix(delimiter):
+			// Up to two quotes right before the closing ones belong to the string.
+			for p.hasPrefix(delimiter + string(quote)) {
+				text.WriteByte(p.next())
+			}
+			for range delimiter {
+				p.next()
+			}
+			return text.String(), nil
+		case p.peek() == '\\' && quote == '"':
+			if trimmed := strings.TrimLeft(string(p.src[p.off+1:]), " \t\r"); strings.HasPrefix(trimmed, "\n") {
+				// A backslash at the end of a line trims the line break and the
+				// whitespace that follows.
+				p.next()
+				for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+					p.next()
+				}
+				continue
+			}
+			if err := p.escape(&text); err != nil {
+				return "", err
+			}
+		default:
+			text.WriteByte(p.next())
+		}
+	}
+}
+
+func (p *tomlParser) escape(text *strings.Builder) error {
+	pos := p.pos()
+	p.next()
+	switch escaped := p.next(); escaped {
+	case 'b':
+		text.WriteByte('\b')
+	case 't':
+		text.WriteByte('\t')
+	case 'n':
+		text.WriteByte('\n')
+	case 'f':
+		text.WriteByte('\f')
+	case 'r':
+		text.WriteByte('\r')
+	case 'e':
+		text.WriteByte('\x1b')
+	case '"', '\\':
+		text.WriteByte(escaped)
+	case 'u', 'U':
+		digits := 4
+		if escaped == 'U' {
+			digits = 8
+		}
+		r, err := p.readHex(digits)
+		if err != nil {
+			return err
+		}
+		text.WriteRune(r)
+	default:
+		return errorAt(pos, "invalid escape sequence \\%c", escaped)
+	}
+	return nil
+}
+
+func isBareKeyByte(c byte) bool {
+	return c == '_' || c == '-' || isAlphanumeric(c)
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a YAML config file with gopkg.in/yaml.v3 and converts its
+// node tree, which keeps key order and positions, into a docValue. Plain
+// scalars are typed after ${VAR} expansion, so an expanded number stays a
+// number; quoted and block scalars are always strings.
+func parseYAML(contents []byte) (*docValue, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	var document yaml.Node
+	if err := decoder.Decode(&document); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &docValue{kind: docNull, pos: position{1, 1}}, nil
+		}
+		return nil, yamlSyntaxError(err)
+	}
+	var next yaml.Node
+	if err := decoder.Decode(&next); err == nil {
+		return nil, errorAt(position{next.Line, 1}, "multiple YAML documents are not supported")
+	} else if !errors.Is(err, io.EOF) {
+		return nil, yamlSyntaxError(err)
+	}
+	if len(document.Content) == 0 {
+		return &docValue{kind: docNull, pos: position{1, 1}}, nil
+	}
+	return convertYAML(document.Content[0])
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// yamlSyntaxError turns a yaml.v3 syntax error into a ParseError. yaml.v3 only
+// reports the line, so the column is left out.
+func yamlSyntaxError(err error) error {
+	match := yamlErrorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(match[1])
+	return &ParseError{Line: line, Err: errors.New(match[2])}
+}
+
+func convertYAML(node *yaml.Node) (*docValue, error) {
+	pos := position{node.Line, node.Column}
+	switch node.Kind {
+	case yaml.AliasNode:
+		return convertYAML(node.Alias)
+	case yaml.MappingNode:
+		object := newObject(pos)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, errorAt(position{key.Line, key.Column}, "keys must be scalars")
+			}
+			if object.field(key.Value) != nil {
+				return nil, errorAt(position{key.Line, key.Column}, "duplicate key %q", key.Value)
+			}
+			field, err := convertYAML(value)
+			if err != nil {
+				return nil, err
+			}
+			object.set(key.Value, field)
+		}
+		return object, nil
+	case yaml.SequenceNode:
+		array := &docValue{kind: docArray, pos: pos}
+		for _, item := range node.Content {
+			value, err := convertYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			array.items = append(array.items, value)
+		}
+		return array, nil
+	case yaml.ScalarNode:
+		text, err := expandEnv(node.Value)
+		if err != nil {
+			return nil, errorAt(pos, "%v", err)
+		}
+		if node.Style&yaml.TaggedStyle != 0 {
+			if node.ShortTag() != "!!str" {
+				return nil, errorAt(pos, "unsupported tag %s", node.Tag)
+			}
+			return &docValue{kind: docString, text: text, pos: pos}, nil
+		}
+		if node.Style != 0 {
+			return &docValue{kind: docString, text: text, pos: pos}, nil
+		}
+		return resolveScalar(text, pos), nil
+	}
+	return nil, errorAt(pos, "unexpected YAML node")
+}
@@ -2,25 +2,37 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/robertomachorro/doormanlb/internal/config"
 	"github.com/robertomachorro/doormanlb/internal/service"
 )
 
+const maxPurgeBodyBytes = 1 << 20
+
 type Handler struct {
-	service service.RequestService
+	service    service.RequestService
+	adminToken string
 }
 
 func NewHandler(service service.RequestService) *Handler {
 	return &Handler{service: service}
 }
 
+// RequireAdminToken protects mutating admin endpoints (such as purge) with a
+// bearer token. Without a token they are disabled.
+func (h *Handler) RequireAdminToken(token string) {
+	h.adminToken = token
+}
+
 func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	if request.URL == nil {
 		http.Error(writer, "invalid request", http.StatusBadRequest)
@@ -37,6 +49,9 @@ func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	case config.AdminPathPrefix + "metrics":
 		h.handleMetrics(writer)
 		return
+	case config.AdminPathPrefix + "purge":
+		h.handlePurge(writer, request)
+		return
 	}
 
 	if request.Method != http.MethodGet {
@@ -46,6 +61,11 @@ func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 
 	if err := h.service.Handle(request.Context(), request, writer); err != nil {
 		log.Printf("request failed: %v", err)
+		if errors.Is(err, service.ErrResponseStarted) {
+			// The status and part of the body are already sent; cut the
+			// connection so the client sees an incomplete response.
+			panic(http.ErrAbortHandler)
+		}
 
 		statusCode := http.StatusBadGateway
 		if errors.Is(err, errBadRequest) {
@@ -67,14 +87,34 @@ func (h *Handler) handleReady(writer http.ResponseWriter, request *http.Request)
 	ctx, cancel := context.WithTimeout(request.Context(), 2*time.Second)
 	defer cancel()
 
+	status, body := http.StatusOK, "ready"
 	if err := h.service.Ready(ctx); err != nil {
-		http.Error(writer, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
-		return
+		status, body = http.StatusServiceUnavailable, fmt.Sprintf("not ready: %v", err)
 	}
 
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	writer.WriteHeader(http.StatusOK)
-	_, _ = writer.Write([]byte("ready"))
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(status)
+	_, _ = writer.Write([]byte(body + "\n" + formatUpstreamHealth(h.service.UpstreamHealth())))
+}
+
+// formatUpstreamHealth lists each upstream service and its state, one per line.
+func formatUpstreamHealth(health map[string]bool) string {
+	serviceURLs := make([]string, 0, len(health))
+	for serviceURL := range health {
+		serviceURLs = append(serviceURLs, serviceURL)
+	}
+	sort.Strings(serviceURLs)
+
+	lines := strings.Builder{}
+	for _, serviceURL := range serviceURLs {
+		state := "healthy"
+		if !health[serviceURL] {
+			state = "unhealthy"
+		}
+		fmt.Fprintf(&lines, "upstream %s %s\n", serviceURL, state)
+	}
+	return lines.String()
 }
 
 func (h *Handler) handleMetrics(writer http.ResponseWriter) {
@@ -83,3 +123,51 @@ func (h *Handler) handleMetrics(writer http.ResponseWriter) {
 		http.Error(writer, "failed to write metrics", http.StatusInternalServerError)
 	}
 }
+
+func (h *Handler) handlePurge(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.adminToken == "" {
+		http.Error(writer, "purge is disabled: ADMIN_TOKEN is not set", http.StatusForbidden)
+		return
+	}
+	if !h.authorizedAdmin(request) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var purge service.PurgeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxPurgeBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&purge); err != nil {
+		http.Error(writer, fmt.Sprintf("invalid purge request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	purged, err := h.service.Purge(request.Context(), purge)
+	if err != nil {
+		log.Printf("purge failed: %v", err)
+
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidPurge) {
+			statusCode = http.StatusBadRequest
+		}
+		http.Error(writer, err.Error(), statusCode)
+		return
+	}
+
+	log.Printf("purged %d cached responses for %+v", purged, purge)
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(map[string]int{"purged": purged})
+}
+
+func (h *Handler) authorizedAdmin(request *http.Request) bool {
+	if h.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
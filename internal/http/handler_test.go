@@ -3,12 +3,14 @@ package http
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/service"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -38,6 +40,22 @@ func TestReadyEndpointNotReady(t *testing.T) {
 	}
 }
 
+func TestReadyEndpointListsUpstreamHealth(t *testing.T) {
+	h := NewHandler(&fakeService{health: map[string]bool{"http://svc-b": false, "http://svc-a": true}})
+	req := httptest.NewRequest(http.MethodGet, "http://localhost"+config.AdminPathPrefix+"ready", nil)
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	want := "ready\nupstream http://svc-a healthy\nupstream http://svc-b unhealthy\n"
+	if rec.Body.String() != want {
+		t.Fatalf("expected body %q, got %q", want, rec.Body.String())
+	}
+}
+
 func TestMetricsEndpoint(t *testing.T) {
 	h := NewHandler(&fakeService{metrics: map[string]uint64{"requests_total": 3}})
 	req := httptest.NewRequest(http.MethodGet, "http://localhost"+config.AdminPathPrefix+"metrics", nil)
@@ -66,10 +84,95 @@ func TestNonAdminPathIsProxied(t *testing.T) {
 	}
 }
 
+func TestProxyErrorAfterHeadersAbortsResponse(t *testing.T) {
+	h := NewHandler(&fakeService{handleErr: fmt.Errorf("%w: upstream reset", service.ErrResponseStarted)})
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/downloads/big", nil)
+	rec := httptest.NewRecorder()
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("expected the handler to abort, got %v", recovered)
+		}
+		if rec.Body.Len() != 0 {
+			t.Fatalf("expected no error text in the body, got %q", rec.Body.String())
+		}
+	}()
+	h.ServeHTTP(rec, req)
+}
+
+func TestPurgeEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		token      string
+		purgeErr   error
+		wantStatus int
+		wantPurge  service.PurgeRequest
+	}{
+		{name: "purges url", method: http.MethodPost, body: `{"url":"/blog/post"}`, token: "secret", wantStatus: http.StatusOK, wantPurge: service.PurgeRequest{URL: "/blog/post"}},
+		{name: "purges tag", method: http.MethodPost, body: `{"tag":"post-42"}`, token: "secret", wantStatus: http.StatusOK, wantPurge: service.PurgeRequest{Tag: "post-42"}},
+		{name: "purges all", method: http.MethodPost, body: `{"all":true}`, token: "secret", wantStatus: http.StatusOK, wantPurge: service.PurgeRequest{All: true}},
+		{name: "rejects get", method: http.MethodGet, token: "secret", wantStatus: http.StatusMethodNotAllowed},
+		{name: "rejects unknown field", method: http.MethodPost, body: `{"path":"/"}`, token: "secret", wantStatus: http.StatusBadRequest},
+		{name: "maps invalid purge", method: http.MethodPost, body: `{}`, token: "secret", purgeErr: service.ErrInvalidPurge, wantStatus: http.StatusBadRequest},
+		{name: "store failure", method: http.MethodPost, body: `{"all":true}`, token: "secret", purgeErr: errors.New("redis down"), wantStatus: http.StatusInternalServerError},
+		{name: "rejects wrong token", method: http.MethodPost, body: `{"all":true}`, token: "other", wantStatus: http.StatusUnauthorized},
+		{name: "disabled without token", method: http.MethodPost, body: `{"all":true}`, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{purged: 4, purgeErr: tt.purgeErr}
+			h := NewHandler(svc)
+			h.RequireAdminToken(tt.token)
+			req := httptest.NewRequest(tt.method, "http://localhost"+config.AdminPathPrefix+"purge", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d (%s)", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if svc.lastPurge != (service.PurgeRequest{}) && tt.purgeErr == nil {
+					t.Fatalf("expected no purge, got %+v", svc.lastPurge)
+				}
+				return
+			}
+			if svc.lastPurge != tt.wantPurge {
+				t.Fatalf("expected purge %+v, got %+v", tt.wantPurge, svc.lastPurge)
+			}
+			if !strings.Contains(rec.Body.String(), "\"purged\":4") {
+				t.Fatalf("expected purged count in body, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPurgeEndpointAcceptsAdminToken(t *testing.T) {
+	h := NewHandler(&fakeService{})
+	h.RequireAdminToken("secret")
+	req := httptest.NewRequest(http.MethodPost, "http://localhost"+config.AdminPathPrefix+"purge", strings.NewReader(`{"all":true}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
 type fakeService struct {
 	handleErr    error
 	readyErr     error
+	purgeErr     error
 	metrics      map[string]uint64
+	health       map[string]bool
+	purged       int
+	lastPurge    service.PurgeRequest
 	handleCalled bool
 }
 
@@ -88,3 +191,15 @@ func (f *fakeService) Metrics() map[string]uint64 {
 	}
 	return f.metrics
 }
+
+func (f *fakeService) Purge(_ context.Context, purge service.PurgeRequest) (int, error) {
+	f.lastPurge = purge
+	if f.purgeErr != nil {
+		return 0, f.purgeErr
+	}
+	return f.purged, nil
+}
+
+func (f *fakeService) UpstreamHealth() map[string]bool {
+	return f.health
+}
@@ -9,8 +9,13 @@ import (
 	"strings"
 )
 
+const VariantSeparator = "."
+
 type Options struct {
 	IgnoreParameters bool
+	// Host, when set, is part of the key so that the same path on different
+	// sites is cached separately. Without it keys depend on the path alone.
+	Host string
 }
 
 func Build(request *http.Request, options Options) string {
@@ -19,6 +24,9 @@ func Build(request *http.Request, options Options) string {
 	}
 
 	keyBuilder := strings.Builder{}
+	if options.Host != "" {
+		keyBuilder.WriteString(strings.ToLower(options.Host))
+	}
 	keyBuilder.WriteString(request.URL.Path)
 
 	if !options.IgnoreParameters {
@@ -33,6 +41,34 @@ func Build(request *http.Request, options Options) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// Variant derives the key of one representation of baseKey, selected by the
+// request's values for the given (canonical, sorted) header names. Variant keys
+// share baseKey as a prefix so they can be purged together with it.
+func Variant(baseKey string, request *http.Request, headers []string) string {
+	keyBuilder := strings.Builder{}
+	for _, name := range headers {
+		keyBuilder.WriteString(strings.ToLower(name))
+		keyBuilder.WriteString(":")
+		keyBuilder.WriteString(normalizeHeaderValue(request.Header.Values(name)))
+		keyBuilder.WriteString("\n")
+	}
+
+	hash := sha256.Sum256([]byte(keyBuilder.String()))
+	return baseKey + VariantSeparator + hex.EncodeToString(hash[:])
+}
+
+func normalizeHeaderValue(values []string) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.ToLower(strings.TrimSpace(part)); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
 func normalizeQuery(values url.Values) string {
 	if len(values) == 0 {
 		return ""
@@ -2,6 +2,7 @@ package keybuilder
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +32,44 @@ func TestBuildIgnoresParametersWhenConfigured(t *testing.T) {
 		t.Fatalf("expected identical keys when parameters are ignored; keyA=%s keyB=%s", keyA, keyB)
 	}
 }
+
+func TestBuildSeparatesHostsWhenConfigured(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+
+	siteA := Build(req, Options{Host: "a.example.com"})
+	siteB := Build(req, Options{Host: "B.example.com"})
+	if siteA == siteB {
+		t.Fatal("expected different hosts to produce different keys")
+	}
+	if siteB != Build(req, Options{Host: "b.example.com"}) {
+		t.Fatal("expected host comparison to ignore case")
+	}
+	if Build(req, Options{}) == siteA {
+		t.Fatal("expected keys without a host to differ from host keys")
+	}
+}
+
+func TestVariantSelectsOnHeaderValues(t *testing.T) {
+	reqA, _ := http.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+	reqA.Header.Set("Accept-Language", "en-US, fr")
+	reqB, _ := http.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+	reqB.Header.Set("Accept-Language", "EN-us,fr")
+	reqC, _ := http.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+	reqC.Header.Set("Accept-Language", "de")
+
+	headers := []string{"Accept-Language"}
+	base := Build(reqA, Options{})
+	keyA := Variant(base, reqA, headers)
+	keyB := Variant(base, reqB, headers)
+	keyC := Variant(base, reqC, headers)
+
+	if !strings.HasPrefix(keyA, base+VariantSeparator) {
+		t.Fatalf("expected variant key to extend base key, got %s", keyA)
+	}
+	if keyA != keyB {
+		t.Fatalf("expected equivalent header values to share a variant; keyA=%s keyB=%s", keyA, keyB)
+	}
+	if keyA == keyC {
+		t.Fatal("expected different header values to produce different variants")
+	}
+}
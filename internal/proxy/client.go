@@ -18,6 +18,14 @@ type Response struct {
 	Body       []byte
 }
 
+// Stream is an upstream response whose body has not been read yet. Callers must
+// close Body.
+type Stream struct {
+	StatusCode int
+	Header     http.Header
+	Body       io.ReadCloser
+}
+
 func NewClient() *Client {
 	return &Client{httpClient: &http.Client{}}
 }
@@ -33,6 +41,27 @@ func (c *Client) Forward(ctx context.Context, upstreamBaseURL string, request *h
 }
 
 func (c *Client) Fetch(ctx context.Context, upstreamBaseURL string, request *http.Request) (*Response, error) {
+	stream, err := c.Open(ctx, upstreamBaseURL, request)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Body.Close()
+
+	body, err := io.ReadAll(stream.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream response: %w", err)
+	}
+
+	return &Response{
+		StatusCode: stream.StatusCode,
+		Header:     stream.Header,
+		Body:       body,
+	}, nil
+}
+
+// Open performs the upstream request and returns as soon as the response
+// headers arrive, leaving the body to be read incrementally.
+func (c *Client) Open(ctx context.Context, upstreamBaseURL string, request *http.Request) (*Stream, error) {
 	targetURL, err := buildTargetURL(upstreamBaseURL, request.URL)
 	if err != nil {
 		return nil, err
@@ -49,24 +78,32 @@ func (c *Client) Fetch(ctx context.Context, upstreamBaseURL string, request *htt
 	if err != nil {
 		return nil, fmt.Errorf("performing upstream request: %w", err)
 	}
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading upstream response: %w", err)
-	}
 
-	return &Response{
+	return &Stream{
 		StatusCode: response.StatusCode,
 		Header:     cloneHeader(response.Header),
-		Body:       body,
+		Body:       response.Body,
 	}, nil
 }
 
 func (r *Response) WriteTo(writer http.ResponseWriter) {
+	r.WriteHeader(writer)
+	_, _ = writer.Write(r.Body)
+}
+
+// WriteHeader copies the response headers and status code to writer, leaving the
+// body to the caller.
+func (r *Response) WriteHeader(writer http.ResponseWriter) {
 	cloneHeaders(r.Header, writer.Header())
 	writer.WriteHeader(r.StatusCode)
-	_, _ = writer.Write(r.Body)
+}
+
+func (r *Response) Clone() *Response {
+	return &Response{
+		StatusCode: r.StatusCode,
+		Header:     cloneHeader(r.Header),
+		Body:       append([]byte(nil), r.Body...),
+	}
 }
 
 func buildTargetURL(upstreamBaseURL string, requestURL *url.URL) (string, error) {
@@ -0,0 +1,47 @@
+package routing
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// rendezvous picks the node with the highest weighted rendezvous score for key.
+// Every key ranks all nodes independently, so adding or removing a node only
+// moves the keys it wins or held, and when the top node is unavailable the key
+// falls to its next-ranked node.
+func rendezvous(nodes []*node, key string) *node {
+	keyHash := hashString(key)
+
+	var selected *node
+	best := math.Inf(-1)
+	for _, n := range nodes {
+		if score := n.rendezvousScore(keyHash); score > best {
+			selected, best = n, score
+		}
+	}
+	return selected
+}
+
+// rendezvousScore maps the pair to a uniform value in (0, 1) and scales it by the
+// node's weight so heavier nodes win proportionally more keys.
+func (n *node) rendezvousScore(keyHash uint64) float64 {
+	uniform := (float64(mix64(keyHash^n.hash)>>11) + 0.5) / (1 << 53)
+	return -float64(n.weight.Load()) / math.Log(uniform)
+}
+
+func hashString(value string) uint64 {
+	hasher := fnv.New64a()
+	_, _ = hasher.Write([]byte(value))
+	return hasher.Sum64()
+}
+
+// mix64 is the splitmix64 finalizer; it spreads the nearby FNV values of similar
+// keys across the whole range.
+func mix64(value uint64) uint64 {
+	value ^= value >> 30
+	value *= 0xbf58476d1ce4e5b9
+	value ^= value >> 27
+	value *= 0x94d049bb133111eb
+	value ^= value >> 31
+	return value
+}
@@ -0,0 +1,73 @@
+package routing
+
+import (
+	"fmt"
+	"testing"
+)
+
+func consistentHashRouter(t *testing.T, services ...string) *Router {
+	t.Helper()
+	router, err := NewRouter(services, "CONSISTENT_HASH")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	return router
+}
+
+func routeOf(router *Router, key string) string {
+	lease := router.AcquireFor(key, nil)
+	defer lease.Release()
+	return lease.URL
+}
+
+func TestConsistentHashKeepsKeysOnTheirNode(t *testing.T) {
+	router := consistentHashRouter(t, "http://svc-a", "http://svc-b", "http://svc-c")
+
+	counts := map[string]int{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("page-%d", i)
+		first := routeOf(router, key)
+		if again := routeOf(router, key); again != first {
+			t.Fatalf("expected %s to stay on %s, got %s", key, first, again)
+		}
+		counts[first]++
+	}
+	for serviceURL, count := range counts {
+		if count < 50 {
+			t.Fatalf("expected keys spread across nodes, %s got only %d of 300", serviceURL, count)
+		}
+	}
+}
+
+func TestConsistentHashMovesFewKeysWhenNodesChange(t *testing.T) {
+	before := consistentHashRouter(t, "http://svc-a", "http://svc-b", "http://svc-c")
+	after := consistentHashRouter(t, "http://svc-a", "http://svc-b", "http://svc-c", "http://svc-d")
+
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("page-%d", i)
+		from, to := routeOf(before, key), routeOf(after, key)
+		if from != to && to != "http://svc-d" {
+			t.Fatalf("expected %s to stay on %s or move to the new node, got %s", key, from, to)
+		}
+	}
+}
+
+func TestConsistentHashFallsBackWhenNodeUnavailable(t *testing.T) {
+	router := consistentHashRouter(t, "http://svc-a", "http://svc-b", "http://svc-c")
+
+	owner := routeOf(router, "page")
+	fallback := router.AcquireFor("page", []string{owner})
+	defer fallback.Release()
+	if fallback.URL == owner {
+		t.Fatalf("expected a different node than the excluded %s", owner)
+	}
+
+	for _, n := range router.state.Load().nodes {
+		if n.url == owner {
+			n.healthy.Store(false)
+		}
+	}
+	if got := routeOf(router, "page"); got != fallback.URL {
+		t.Fatalf("expected unhealthy %s to fall back to %s, got %s", owner, fallback.URL, got)
+	}
+}
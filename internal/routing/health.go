@@ -0,0 +1,142 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HealthCheck configures the active probe of one service.
+type HealthCheck struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	ExpectedStatus     int
+}
+
+type healthProbes struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// StartHealthChecks probes the services named in checks (by URL) in the
+// background. A node leaves rotation after UnhealthyThreshold consecutive failed
+// probes and returns after HealthyThreshold consecutive successful ones. Calling
+// it again, such as after Update, replaces the running probes; services no
+// longer probed are put back in rotation.
+func (r *Router) StartHealthChecks(checks map[string]HealthCheck) {
+	r.probesMu.Lock()
+	defer r.probesMu.Unlock()
+	r.stopProbes()
+
+	probes := &healthProbes{stop: make(chan struct{})}
+	client := &http.Client{
+		// A redirect is an answer; only the status the service itself returns counts.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	for _, n := range r.state.Load().nodes {
+		check, ok := checks[n.url]
+		if !ok {
+			if n.healthy.CompareAndSwap(false, true) {
+				log.Printf("upstream %s marked healthy: no longer health checked", n.url)
+			}
+			continue
+		}
+		probes.wg.Add(1)
+		go func(n *node) {
+			defer probes.wg.Done()
+			probeLoop(client, n, check, probes.stop)
+		}(n)
+	}
+
+	r.probes = probes
+}
+
+// Close stops health checking.
+func (r *Router) Close() {
+	r.probesMu.Lock()
+	defer r.probesMu.Unlock()
+	r.stopProbes()
+}
+
+func (r *Router) stopProbes() {
+	if r.probes == nil {
+		return
+	}
+	r.probes.once.Do(func() { close(r.probes.stop) })
+	r.probes.wg.Wait()
+	r.probes = nil
+}
+
+// Health reports whether each service is currently in rotation, by URL.
+func (r *Router) Health() map[string]bool {
+	nodes := r.state.Load().nodes
+	health := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		health[n.url] = n.healthy.Load()
+	}
+	return health
+}
+
+func probeLoop(client *http.Client, n *node, check HealthCheck, stop <-chan struct{}) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+
+	successes, failures := 0, 0
+	for {
+		if err := probe(client, n.url, check); err != nil {
+			successes = 0
+			failures++
+			if failures >= check.UnhealthyThreshold && n.healthy.CompareAndSwap(true, false) {
+				log.Printf("upstream %s marked unhealthy: %v", n.url, err)
+			}
+		} else {
+			failures = 0
+			successes++
+			if successes >= check.HealthyThreshold && n.healthy.CompareAndSwap(false, true) {
+				log.Printf("upstream %s marked healthy", n.url)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probe(client *http.Client, serviceURL string, check HealthCheck) error {
+	base, err := url.Parse(serviceURL)
+	if err != nil {
+		return fmt.Errorf("invalid service url: %w", err)
+	}
+	target := base.ResolveReference(&url.URL{Path: check.Path})
+
+	ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return fmt.Errorf("building probe: %w", err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != check.ExpectedStatus {
+		return fmt.Errorf("probe returned status %d, expected %d", response.StatusCode, check.ExpectedStatus)
+	}
+	return nil
+}
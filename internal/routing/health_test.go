@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecksTakeFailingNodeOutOfRotation(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/healthz" {
+			t.Errorf("unexpected probe path %q", request.URL.Path)
+		}
+		if failing.Load() {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+
+	router, err := NewRouter([]string{flaky.URL, "http://svc-b"}, "ROUND_ROBIN")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.StartHealthChecks(map[string]HealthCheck{
+		flaky.URL: {
+			Path:               "/healthz",
+			Interval:           5 * time.Millisecond,
+			Timeout:            time.Second,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+			ExpectedStatus:     http.StatusOK,
+		},
+	})
+	defer router.Close()
+
+	waitForHealth(t, router, flaky.URL, false)
+	for i := 0; i < 4; i++ {
+		lease := router.Acquire()
+		if lease.URL != "http://svc-b" {
+			t.Fatalf("expected unhealthy node to be skipped, got %s", lease.URL)
+		}
+		lease.Release()
+	}
+
+	failing.Store(false)
+	waitForHealth(t, router, flaky.URL, true)
+}
+
+func TestAllNodesUnhealthyKeepsRouting(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "LEAST_CONNECTIONS")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	for _, n := range router.state.Load().nodes {
+		n.healthy.Store(false)
+	}
+
+	lease := router.Acquire()
+	defer lease.Release()
+	if lease.URL != "http://svc-a" {
+		t.Fatalf("expected routing to fall back to every node, got %s", lease.URL)
+	}
+}
+
+func waitForHealth(t *testing.T, router *Router, serviceURL string, healthy bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for router.Health()[serviceURL] != healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s healthy=%v", serviceURL, healthy)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
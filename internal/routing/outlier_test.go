@@ -0,0 +1,107 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConsecutiveFailuresEjectNode(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "LEAST_CONNECTIONS")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.SetOutlierDetection(OutlierDetection{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    50 * time.Millisecond,
+		MaxEjectionPercent:  50,
+	})
+
+	// Least connections routes to svc-a while it is idle.
+	for _, outcome := range []error{errors.New("connection refused"), nil} {
+		lease := router.Acquire()
+		if lease.URL != "http://svc-a" {
+			t.Fatalf("expected svc-a before ejection, got %s", lease.URL)
+		}
+		lease.Report(http.StatusBadGateway, outcome)
+		lease.Release()
+	}
+
+	for i := 0; i < 3; i++ {
+		lease := router.Acquire()
+		if lease.URL != "http://svc-b" {
+			t.Fatalf("expected svc-a to be ejected, got %s", lease.URL)
+		}
+		lease.Release()
+	}
+	if got := router.Metrics()[`upstream_ejections_total{service="http://svc-a"}`]; got != 1 {
+		t.Fatalf("expected one ejection, got %d", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	lease := router.Acquire()
+	defer lease.Release()
+	if lease.URL != "http://svc-a" {
+		t.Fatalf("expected svc-a back after its ejection, got %s", lease.URL)
+	}
+}
+
+func TestSuccessResetsConsecutiveFailures(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "LEAST_CONNECTIONS")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.SetOutlierDetection(OutlierDetection{ConsecutiveFailures: 2, BaseEjectionTime: time.Minute, MaxEjectionPercent: 100})
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusServiceUnavailable} {
+		lease := router.Acquire()
+		lease.Report(status, nil)
+		lease.Release()
+	}
+	// Cancelled requests are not held against the node.
+	lease := router.Acquire()
+	lease.Report(0, context.Canceled)
+	lease.Release()
+
+	if got := router.Metrics()[`upstream_ejected{service="http://svc-a"}`]; got != 0 {
+		t.Fatal("expected svc-a to stay in rotation")
+	}
+}
+
+func TestMaxEjectionPercentLimitsEjections(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "ROUND_ROBIN")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.SetOutlierDetection(OutlierDetection{ConsecutiveFailures: 1, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50})
+
+	for i := 0; i < 4; i++ {
+		lease := router.Acquire()
+		lease.Report(http.StatusInternalServerError, nil)
+		lease.Release()
+	}
+
+	metrics := router.Metrics()
+	ejected := metrics[`upstream_ejected{service="http://svc-a"}`] + metrics[`upstream_ejected{service="http://svc-b"}`]
+	if ejected != 1 {
+		t.Fatalf("expected exactly one of two nodes ejected, got %d", ejected)
+	}
+}
+
+func TestLeaseReportsLatency(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a"}, "ROUND_ROBIN")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	lease := router.Acquire()
+	time.Sleep(5 * time.Millisecond)
+	lease.Report(http.StatusOK, nil)
+	lease.Release()
+
+	if lease.Latency() < 5*time.Millisecond {
+		t.Fatalf("expected latency of at least 5ms, got %s", lease.Latency())
+	}
+}
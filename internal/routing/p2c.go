@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peakEWMADecay is how quickly old latency samples lose weight: a sample is worth
+// 1/e of a fresh one after this long.
+const peakEWMADecay = 10 * time.Second
+
+// latencyEWMA is a node's peak-sensitive, exponentially weighted moving average of
+// response latency. Slower samples replace the average outright, so a backend
+// that starts struggling is avoided at once; faster ones pull it down gradually.
+// Without new samples the average decays toward zero, so an avoided backend is
+// tried again once its last slow sample is old.
+type latencyEWMA struct {
+	mu      sync.Mutex
+	average float64
+	stamp   time.Time
+}
+
+func (e *latencyEWMA) observe(latency time.Duration, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sample := float64(latency)
+	if sample > e.average || e.stamp.IsZero() {
+		e.average = sample
+	} else {
+		decay := math.Exp(-float64(now.Sub(e.stamp)) / float64(peakEWMADecay))
+		e.average = e.average*decay + sample*(1-decay)
+	}
+	e.stamp = now
+}
+
+func (e *latencyEWMA) value(now time.Time) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	elapsed := max(now.Sub(e.stamp), 0)
+	return e.average * math.Exp(-float64(elapsed)/float64(peakEWMADecay))
+}
+
+// powerOfTwoChoices picks two distinct nodes at random and returns the one with
+// the lower cost. It avoids both the herding of always choosing the global
+// minimum and the cost of scanning every node.
+func powerOfTwoChoices(nodes []*node, cost func(*node) float64) *node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	i := rand.IntN(len(nodes))
+	j := rand.IntN(len(nodes) - 1)
+	if j >= i {
+		j++
+	}
+	first, second := nodes[i], nodes[j]
+	if cost(second) < cost(first) {
+		return second
+	}
+	return first
+}
+
+// loadCost is a node's in-flight requests relative to its weight.
+func loadCost(n *node) float64 {
+	return float64(atomic.LoadInt64(&n.inflight)) / float64(n.weight.Load())
+}
+
+// peakEWMACost is a node's latency average scaled by the requests it would have
+// in flight if picked, relative to its weight. Nodes without samples cost
+// nothing, so new or recovered nodes are tried promptly.
+func peakEWMACost(n *node) float64 {
+	return n.latency.value(time.Now()) * float64(atomic.LoadInt64(&n.inflight)+1) / float64(n.weight.Load())
+}
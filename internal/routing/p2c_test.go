@@ -0,0 +1,107 @@
+package routing
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestP2CPrefersLessLoadedNode(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "P2C")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	busy := []*Lease{router.Acquire(), router.Acquire(), router.Acquire()}
+	counts := map[string]int{}
+	for _, lease := range busy {
+		counts[lease.URL]++
+	}
+	if counts["http://svc-a"] != 2 && counts["http://svc-b"] != 2 {
+		t.Fatalf("expected in-flight requests to stay balanced, got %v", counts)
+	}
+
+	idle := "http://svc-a"
+	if counts["http://svc-a"] == 2 {
+		idle = "http://svc-b"
+	}
+	for i := 0; i < 10; i++ {
+		lease := router.Acquire()
+		if lease.URL != idle {
+			t.Fatalf("expected the less loaded %s, got %s", idle, lease.URL)
+		}
+		lease.Release()
+	}
+}
+
+func TestPeakEWMAAvoidsSlowNode(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "PEAK_EWMA")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	latencies := map[string]time.Duration{"http://svc-a": time.Second, "http://svc-b": 10 * time.Millisecond}
+	for i := 0; i < 20; i++ {
+		lease := router.Acquire()
+		lease.start = time.Now().Add(-latencies[lease.URL])
+		lease.Report(http.StatusOK, nil)
+		lease.Release()
+	}
+
+	for i := 0; i < 10; i++ {
+		lease := router.Acquire()
+		if lease.URL != "http://svc-b" {
+			t.Fatalf("expected the fast svc-b, got %s", lease.URL)
+		}
+		lease.Report(http.StatusOK, nil)
+		lease.Release()
+	}
+}
+
+func TestLatencyEWMATracksPeaksAndDecays(t *testing.T) {
+	var ewma latencyEWMA
+	now := time.Now()
+
+	ewma.observe(10*time.Millisecond, now)
+	ewma.observe(100*time.Millisecond, now)
+	if got := time.Duration(ewma.value(now)); got != 100*time.Millisecond {
+		t.Fatalf("expected a slower sample to replace the average, got %s", got)
+	}
+
+	ewma.observe(10*time.Millisecond, now.Add(peakEWMADecay))
+	got := time.Duration(ewma.value(now.Add(peakEWMADecay)))
+	if got <= 10*time.Millisecond || got >= 50*time.Millisecond {
+		t.Fatalf("expected the average to decay toward 10ms, got %s", got)
+	}
+
+	got = time.Duration(ewma.value(now.Add(5 * peakEWMADecay)))
+	if got >= time.Millisecond {
+		t.Fatalf("expected the average to decay without new samples, got %s", got)
+	}
+}
+
+func TestPeakEWMARetriesSlowNodeAfterDecay(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "PEAK_EWMA")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	now := time.Now()
+	for _, n := range router.state.Load().nodes {
+		if n.url == "http://svc-a" {
+			n.latency.observe(time.Second, now.Add(-10*peakEWMADecay))
+		} else {
+			n.latency.observe(10*time.Millisecond, now)
+		}
+	}
+
+	picks := map[string]int{}
+	for i := 0; i < 100; i++ {
+		lease := router.Acquire()
+		picks[lease.URL]++
+		lease.Release()
+	}
+	if picks["http://svc-a"] == 0 {
+		t.Fatalf("expected svc-a to get traffic again once its slow sample decayed, got %v", picks)
+	}
+}
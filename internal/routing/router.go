@@ -1,59 +1,228 @@
 package routing
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Router struct {
+	// state is replaced as a whole by Update and SetOutlierDetection.
+	state atomic.Pointer[routerState]
+	next  uint64
+	// ejectMu serializes ejections so MaxEjectionPercent holds.
+	ejectMu sync.Mutex
+	// weightMu guards the current weights of smooth weighted round robin.
+	weightMu sync.Mutex
+
+	probesMu sync.Mutex
+	probes   *healthProbes
+}
+
+type routerState struct {
 	strategy string
 	nodes    []*node
-	next     uint64
+	outliers OutlierDetection
 }
 
 type node struct {
 	url      string
+	hash     uint64
+	weight   atomic.Int64
+	current  int64
 	inflight int64
+	healthy  atomic.Bool
+	latency  latencyEWMA
+
+	// Passive outlier detection state.
+	failures     atomic.Int64
+	ejectedUntil atomic.Int64
+	streak       atomic.Int64
+	ejections    atomic.Uint64
+}
+
+// OutlierDetection ejects nodes that keep failing real traffic. A node is ejected
+// after ConsecutiveFailures connection errors or 5xx responses in a row, for
+// BaseEjectionTime multiplied by the number of ejections since its last success
+// (capped at MaxEjectionTime). At most MaxEjectionPercent of the nodes are
+// ejected at once. A zero ConsecutiveFailures disables detection.
+type OutlierDetection struct {
+	ConsecutiveFailures int
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
+	MaxEjectionPercent  int
 }
 
+// Service is an upstream service and its share of traffic under the weighted
+// strategies. Weights below 1 count as 1.
+type Service struct {
+	URL    string
+	Weight int
+}
+
+// NewRouter routes across equally weighted services.
 func NewRouter(services []string, strategy string) (*Router, error) {
-	if len(services) == 0 {
-		return nil, errors.New("at least one service is required")
+	weighted := make([]Service, 0, len(services))
+	for _, serviceURL := range services {
+		weighted = append(weighted, Service{URL: serviceURL, Weight: 1})
+	}
+	return NewWeightedRouter(weighted, strategy)
+}
+
+// NewWeightedRouter routes across services in proportion to their weights. Only
+// ROUND_ROBIN ignores weights.
+func NewWeightedRouter(services []Service, strategy string) (*Router, error) {
+	router := &Router{}
+	router.state.Store(&routerState{})
+	if err := router.Update(services, strategy); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// Update replaces the services and strategy while the router is in use. Services
+// that are kept, by URL, keep their in-flight count, health and latency state;
+// requests already routed to removed services finish normally.
+func (r *Router) Update(services []Service, strategy string) error {
+	if err := Validate(services, strategy); err != nil {
+		return err
+	}
+
+	current := r.state.Load()
+	existing := make(map[string][]*node, len(current.nodes))
+	for _, n := range current.nodes {
+		existing[n.url] = append(existing[n.url], n)
 	}
 
 	nodes := make([]*node, 0, len(services))
-	for _, serviceURL := range services {
-		nodes = append(nodes, &node{url: serviceURL})
+	for _, svc := range services {
+		var n *node
+		if reused := existing[svc.URL]; len(reused) > 0 {
+			n, existing[svc.URL] = reused[0], reused[1:]
+		} else {
+			n = &node{url: svc.URL, hash: hashString(svc.URL)}
+			n.healthy.Store(true)
+		}
+		n.weight.Store(int64(max(svc.Weight, 1)))
+		nodes = append(nodes, n)
+	}
+
+	r.state.Store(&routerState{strategy: strategy, nodes: nodes, outliers: current.outliers})
+	return nil
+}
+
+// Validate reports whether a router can route across services with strategy, so
+// several routers can be checked before any of them is updated.
+func Validate(services []Service, strategy string) error {
+	if len(services) == 0 {
+		return errors.New("at least one service is required")
 	}
 
 	switch strategy {
-	case "ROUND_ROBIN", "LEAST_CONNECTIONS":
+	case "ROUND_ROBIN", "WEIGHTED_ROUND_ROBIN", "LEAST_CONNECTIONS", "P2C", "PEAK_EWMA", "CONSISTENT_HASH":
 	default:
-		return nil, fmt.Errorf("unsupported strategy %q", strategy)
+		return fmt.Errorf("unsupported strategy %q", strategy)
 	}
+	return nil
+}
 
-	return &Router{strategy: strategy, nodes: nodes}, nil
+// SetOutlierDetection enables passive outlier detection, or changes its settings.
+func (r *Router) SetOutlierDetection(detection OutlierDetection) {
+	current := r.state.Load()
+	r.state.Store(&routerState{strategy: current.strategy, nodes: current.nodes, outliers: detection})
 }
 
 func (r *Router) Acquire() *Lease {
-	n := r.selectNode()
+	return r.AcquireFor("", nil)
+}
+
+// AcquireExcluding acquires a node other than the excluded service URLs, such as
+// the ones a request already failed on. It returns nil when none is left.
+func (r *Router) AcquireExcluding(exclude []string) *Lease {
+	return r.AcquireFor("", exclude)
+}
+
+// AcquireFor acquires a node for a request identified by key, outside the
+// excluded service URLs. CONSISTENT_HASH sends equal keys to the same node;
+// requests without a key are spread round robin. Other strategies ignore key.
+func (r *Router) AcquireFor(key string, exclude []string) *Lease {
+	n := r.selectNode(key, exclude)
+	if n == nil {
+		return nil
+	}
 	atomic.AddInt64(&n.inflight, 1)
-	return &Lease{URL: n.url, releaseFn: func() { atomic.AddInt64(&n.inflight, -1) }}
+
+	lease := &Lease{URL: n.url, start: time.Now()}
+	lease.releaseFn = func() {
+		atomic.AddInt64(&n.inflight, -1)
+		r.observe(n, lease)
+	}
+	return lease
+}
+
+// CanAvoid reports whether there is a node outside the excluded service URLs.
+func (r *Router) CanAvoid(exclude []string) bool {
+	return len(without(r.state.Load().nodes, exclude)) > 0
+}
+
+// Metrics reports per-node health and ejection state, labelled by service URL.
+func (r *Router) Metrics() map[string]uint64 {
+	now := time.Now()
+	nodes := r.state.Load().nodes
+	metrics := make(map[string]uint64, 3*len(nodes))
+	for _, n := range nodes {
+		metrics[fmt.Sprintf("upstream_healthy{service=%q}", n.url)] = boolMetric(n.healthy.Load())
+		metrics[fmt.Sprintf("upstream_ejected{service=%q}", n.url)] = boolMetric(n.ejected(now))
+		metrics[fmt.Sprintf("upstream_ejections_total{service=%q}", n.url)] = n.ejections.Load()
+	}
+	return metrics
 }
 
-func (r *Router) selectNode() *node {
-	if r.strategy == "ROUND_ROBIN" {
+func (r *Router) selectNode(key string, exclude []string) *node {
+	state := r.state.Load()
+	nodes := state.available()
+	if len(exclude) > 0 {
+		nodes = without(nodes, exclude)
+		if len(nodes) == 0 {
+			// Unhealthy or ejected nodes beat giving up on the request.
+			nodes = without(state.nodes, exclude)
+		}
+		if len(nodes) == 0 {
+			return nil
+		}
+	}
+	switch state.strategy {
+	case "ROUND_ROBIN":
+		index := atomic.AddUint64(&r.next, 1)
+		return nodes[(index-1)%uint64(len(nodes))]
+	case "WEIGHTED_ROUND_ROBIN":
+		return r.smoothWeighted(nodes)
+	case "P2C":
+		return powerOfTwoChoices(nodes, loadCost)
+	case "PEAK_EWMA":
+		return powerOfTwoChoices(nodes, peakEWMACost)
+	case "CONSISTENT_HASH":
+		if key != "" {
+			return rendezvous(nodes, key)
+		}
 		index := atomic.AddUint64(&r.next, 1)
-		return r.nodes[(index-1)%uint64(len(r.nodes))]
+		return nodes[(index-1)%uint64(len(nodes))]
 	}
 
-	selected := r.nodes[0]
+	// Least connections relative to weight: load/weight is compared by cross
+	// multiplying to stay in integers.
+	selected := nodes[0]
 	selectedLoad := atomic.LoadInt64(&selected.inflight)
-	for i := 1; i < len(r.nodes); i++ {
-		current := r.nodes[i]
+	for i := 1; i < len(nodes); i++ {
+		current := nodes[i]
 		currentLoad := atomic.LoadInt64(&current.inflight)
-		if currentLoad < selectedLoad {
+		if currentLoad*selected.weight.Load() < selectedLoad*current.weight.Load() {
 			selected = current
 			selectedLoad = currentLoad
 		}
@@ -62,10 +231,153 @@ func (r *Router) selectNode() *node {
 	return selected
 }
 
+// smoothWeighted picks nodes the way nginx's smooth weighted round robin does:
+// every pick raises each node's current weight by its weight, and the highest
+// is chosen and lowered by the total. Heavier nodes get proportionally more
+// picks, interleaved rather than in bursts.
+func (r *Router) smoothWeighted(nodes []*node) *node {
+	r.weightMu.Lock()
+	defer r.weightMu.Unlock()
+
+	var selected *node
+	var total int64
+	for _, n := range nodes {
+		weight := n.weight.Load()
+		n.current += weight
+		total += weight
+		if selected == nil || n.current > selected.current {
+			selected = n
+		}
+	}
+	selected.current -= total
+	return selected
+}
+
+// available returns the healthy, non-ejected nodes. If there are none, all of
+// them are returned, since refusing every request would not help.
+func (s *routerState) available() []*node {
+	now := time.Now()
+	usable := 0
+	for _, n := range s.nodes {
+		if n.usable(now) {
+			usable++
+		}
+	}
+	if usable == len(s.nodes) || usable == 0 {
+		return s.nodes
+	}
+
+	nodes := make([]*node, 0, usable)
+	for _, n := range s.nodes {
+		if n.usable(now) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func without(nodes []*node, exclude []string) []*node {
+	kept := make([]*node, 0, len(nodes))
+	for _, n := range nodes {
+		if !slices.Contains(exclude, n.url) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func (n *node) usable(now time.Time) bool {
+	return n.healthy.Load() && !n.ejected(now)
+}
+
+func (n *node) ejected(now time.Time) bool {
+	return now.UnixNano() < n.ejectedUntil.Load()
+}
+
+// observe feeds the outcome reported on a released lease to latency tracking
+// and outlier detection.
+func (r *Router) observe(n *node, lease *Lease) {
+	if !lease.reported {
+		return
+	}
+	n.latency.observe(lease.latency, time.Now())
+	outliers := r.state.Load().outliers
+	if outliers.ConsecutiveFailures <= 0 {
+		return
+	}
+	if !lease.failed {
+		n.failures.Store(0)
+		n.streak.Store(0)
+		return
+	}
+	if n.failures.Add(1) >= int64(outliers.ConsecutiveFailures) {
+		r.eject(n)
+	}
+}
+
+func (r *Router) eject(n *node) {
+	r.ejectMu.Lock()
+	defer r.ejectMu.Unlock()
+
+	state := r.state.Load()
+	outliers := state.outliers
+	now := time.Now()
+	if n.ejected(now) || !slices.Contains(state.nodes, n) {
+		return
+	}
+	ejected := 0
+	for _, candidate := range state.nodes {
+		if candidate.ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > outliers.MaxEjectionPercent*len(state.nodes) {
+		return
+	}
+
+	duration := outliers.BaseEjectionTime * time.Duration(n.streak.Add(1))
+	if outliers.MaxEjectionTime > 0 && duration > outliers.MaxEjectionTime {
+		duration = outliers.MaxEjectionTime
+	}
+	n.ejectedUntil.Store(now.Add(duration).UnixNano())
+	n.failures.Store(0)
+	n.ejections.Add(1)
+	log.Printf("upstream %s ejected for %s after %d consecutive failures", n.url, duration, outliers.ConsecutiveFailures)
+}
+
+func boolMetric(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
 type Lease struct {
 	URL       string
 	released  atomic.Bool
 	releaseFn func()
+
+	start    time.Time
+	latency  time.Duration
+	reported bool
+	failed   bool
+}
+
+// Report records how the request sent through the lease ended, before Release.
+// Connection errors and 5xx responses count as failures; requests cancelled by
+// the client say nothing about the node and are not recorded.
+func (l *Lease) Report(statusCode int, err error) {
+	if l == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	l.reported = true
+	l.failed = err != nil || statusCode >= http.StatusInternalServerError
+	l.latency = time.Since(l.start)
+}
+
+// Latency is the time from Acquire until Report.
+func (l *Lease) Latency() time.Duration {
+	return l.latency
 }
 
 func (l *Lease) Release() {
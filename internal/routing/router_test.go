@@ -45,3 +45,96 @@ func TestLeastConnectionsPrefersLessBusyNode(t *testing.T) {
 	lease1.Release()
 	lease2.Release()
 }
+
+func TestAcquireExcludingSkipsTriedNodes(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "ROUND_ROBIN")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		lease := router.AcquireExcluding([]string{"http://svc-a"})
+		if lease.URL != "http://svc-b" {
+			t.Fatalf("expected svc-b, got %s", lease.URL)
+		}
+		lease.Release()
+	}
+
+	if lease := router.AcquireExcluding([]string{"http://svc-a", "http://svc-b"}); lease != nil {
+		t.Fatalf("expected no lease once every node is excluded, got %s", lease.URL)
+	}
+	if router.CanAvoid([]string{"http://svc-a", "http://svc-b"}) {
+		t.Fatal("expected no node outside the excluded ones")
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	router, err := NewWeightedRouter([]Service{
+		{URL: "a", Weight: 5},
+		{URL: "b", Weight: 1},
+		{URL: "c", Weight: 1},
+	}, "WEIGHTED_ROUND_ROBIN")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	got := ""
+	for i := 0; i < 14; i++ {
+		lease := router.Acquire()
+		got += lease.URL
+		lease.Release()
+	}
+	if want := "aabacaaaabacaa"; got != want {
+		t.Fatalf("expected picks %s, got %s", want, got)
+	}
+}
+
+func TestLeastConnectionsAccountsForWeight(t *testing.T) {
+	router, err := NewWeightedRouter([]Service{
+		{URL: "http://svc-a", Weight: 1},
+		{URL: "http://svc-b", Weight: 3},
+	}, "LEAST_CONNECTIONS")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[router.Acquire().URL]++
+	}
+	if counts["http://svc-a"] != 2 || counts["http://svc-b"] != 6 {
+		t.Fatalf("expected in-flight requests split 2:6, got %v", counts)
+	}
+}
+
+func TestUpdateKeepsStateOfRemainingNodes(t *testing.T) {
+	router, err := NewRouter([]string{"http://svc-a", "http://svc-b"}, "LEAST_CONNECTIONS")
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	busy := router.Acquire()
+	if busy.URL != "http://svc-a" {
+		t.Fatalf("expected first lease to route to svc-a, got %s", busy.URL)
+	}
+	removed := router.Acquire()
+
+	if err := router.Update([]Service{{URL: "http://svc-a", Weight: 1}, {URL: "http://svc-c", Weight: 1}}, "LEAST_CONNECTIONS"); err != nil {
+		t.Fatalf("updating router: %v", err)
+	}
+	if lease := router.Acquire(); lease.URL != "http://svc-c" {
+		t.Fatalf("expected svc-a to keep its in-flight request, got %s", lease.URL)
+	}
+	removed.Release()
+	busy.Release()
+
+	if health := router.Health(); len(health) != 2 || !health["http://svc-a"] || !health["http://svc-c"] {
+		t.Fatalf("expected the updated services, got %v", health)
+	}
+	if err := router.Update(nil, "LEAST_CONNECTIONS"); err == nil {
+		t.Fatal("expected an update without services to fail")
+	}
+	if err := router.Update([]Service{{URL: "http://svc-a"}}, "RANDOM"); err == nil {
+		t.Fatal("expected an update with an unknown strategy to fail")
+	}
+}
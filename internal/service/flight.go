@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/robertomachorro/doormanlb/internal/keybuilder"
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+)
+
+// flightChunkSize is how much of the upstream body the leader relays at a time.
+const flightChunkSize = 32 << 10
+
+// errLeaderFailed is returned to requests that waited on a flight whose leader
+// failed before receiving a response.
+var errLeaderFailed = errors.New("coalesced request failed")
+
+// flight is an upstream response a leader is streaming to its client. Requests
+// in the same process waiting on the same cache key attach to it and receive the
+// body as it arrives, instead of waiting for the cache write and re-reading it.
+type flight struct {
+	ready chan struct{}
+
+	// Set by start before ready is closed.
+	head      *proxy.Response
+	vary      []string
+	variant   string
+	shareable bool
+
+	mu      sync.Mutex
+	body    []byte
+	done    bool
+	err     error
+	updated chan struct{}
+}
+
+func newFlight() *flight {
+	return &flight{ready: make(chan struct{}), updated: make(chan struct{})}
+}
+
+// start publishes the response head. Only responses that may be cached are
+// shared; when they vary, only with requests selecting the same representation.
+func (f *flight) start(head *proxy.Response, vary []string, request *http.Request, shareable bool) {
+	f.head = head
+	f.vary = vary
+	if len(vary) > 0 {
+		f.variant = keybuilder.Variant("", request, vary)
+	}
+	f.shareable = shareable
+	close(f.ready)
+}
+
+func (f *flight) append(chunk []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.body = append(f.body, chunk...)
+	f.notifyLocked()
+}
+
+// finish marks the body complete, or failed when err is set. Only the first call
+// takes effect. A flight finished before start is never shared; if it failed,
+// its waiting requests fail with it.
+func (f *flight) finish(err error) {
+	f.mu.Lock()
+	if !f.done {
+		f.done = true
+		f.err = err
+		f.notifyLocked()
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-f.ready:
+	default:
+		close(f.ready)
+	}
+}
+
+func (f *flight) notifyLocked() {
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// accepts waits for the response head and reports whether request may share it.
+// It fails with errLeaderFailed if the flight failed without a response.
+func (f *flight) accepts(ctx context.Context, request *http.Request) (bool, error) {
+	select {
+	case <-f.ready:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	if f.head == nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.err != nil {
+			return false, fmt.Errorf("%w: %w", errLeaderFailed, f.err)
+		}
+		return false, nil
+	}
+	if !f.shareable {
+		return false, nil
+	}
+	return len(f.vary) == 0 || keybuilder.Variant("", request, f.vary) == f.variant, nil
+}
+
+// streamTo writes the shared response to writer as the leader receives it.
+func (f *flight) streamTo(ctx context.Context, writer http.ResponseWriter) error {
+	f.head.WriteHeader(writer)
+	flusher, _ := writer.(http.Flusher)
+
+	written := 0
+	for {
+		f.mu.Lock()
+		chunk := f.body[written:]
+		done, err, updated := f.done, f.err, f.updated
+		f.mu.Unlock()
+
+		if len(chunk) > 0 {
+			_, _ = writer.Write(chunk)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			written += len(chunk)
+		}
+		if done {
+			return err
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// relay copies the upstream body to the leader's client and the flight, and
+// returns the complete body for the cache.
+func relay(body io.Reader, writer http.ResponseWriter, inFlight *flight) ([]byte, error) {
+	flusher, _ := writer.(http.Flusher)
+	buffer := make([]byte, flightChunkSize)
+
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			inFlight.append(buffer[:n])
+			// Keep reading after a failed client write so followers and the cache still get the body.
+			if _, writeErr := writer.Write(buffer[:n]); writeErr == nil && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			inFlight.mu.Lock()
+			defer inFlight.mu.Unlock()
+			return inFlight.body, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/robertomachorro/doormanlb/internal/cache"
+	"github.com/robertomachorro/doormanlb/internal/config"
+)
+
+// ErrInvalidPurge is returned for purge requests that do not name exactly one
+// target, or that leave out the host once virtual hosts are configured.
+var ErrInvalidPurge = errors.New("invalid purge request")
+
+// PurgeRequest selects the cached responses to evict. Exactly one of URL,
+// Prefix, Tag and All must be set.
+type PurgeRequest struct {
+	// URL purges a single page (and its Vary variants), keyed the same way requests are.
+	URL string `json:"url,omitempty"`
+	// Prefix purges every page whose path starts with it.
+	Prefix string `json:"prefix,omitempty"`
+	// Tag purges every page whose response listed it in Surrogate-Key or Cache-Tag.
+	Tag string `json:"tag,omitempty"`
+	// All purges the whole cache.
+	All bool `json:"all,omitempty"`
+	// Host limits the purge to one virtual host. URL and prefix purges need
+	// it, or a URL naming the host, once virtual hosts are configured; tag
+	// purges apply to every host without it. It is ignored otherwise.
+	Host string `json:"host,omitempty"`
+}
+
+func (p PurgeRequest) validate() error {
+	targets := 0
+	for _, set := range []bool{p.URL != "", p.Prefix != "", p.Tag != "", p.All} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return fmt.Errorf("%w: set exactly one of url, prefix, tag or all", ErrInvalidPurge)
+	}
+	return nil
+}
+
+// Purge evicts the cached responses selected by purge and reports how many were removed.
+func (s *CachingService) Purge(ctx context.Context, purge PurgeRequest) (int, error) {
+	if err := purge.validate(); err != nil {
+		return 0, err
+	}
+	if s.cache == nil {
+		return 0, errors.New("purge requires a cache store")
+	}
+	purger, ok := s.cache.(cache.Purger)
+	if !ok {
+		return 0, errors.New("cache store does not support purging")
+	}
+
+	var (
+		removed int
+		err     error
+	)
+	cfg := s.current().config
+	host := cacheHost(cfg, purge.Host)
+	switch {
+	case purge.URL != "":
+		var key string
+		key, err = s.purgeKey(cfg, purge.URL, host)
+		if err != nil {
+			return 0, err
+		}
+		removed, err = purger.PurgeKey(ctx, key)
+	case purge.Prefix != "":
+		if cfg.UsesHosts() && host == "" {
+			return 0, fmt.Errorf("%w: prefix purges need a host once virtual hosts are configured", ErrInvalidPurge)
+		}
+		removed, err = purger.PurgePrefix(ctx, host, purge.Prefix)
+	case purge.Tag != "":
+		removed, err = purger.PurgeTag(ctx, host, purge.Tag)
+	default:
+		removed, err = purger.PurgeAll(ctx)
+	}
+
+	s.stats.cachePurgedKeys.Add(uint64(removed))
+	if err != nil {
+		return removed, fmt.Errorf("purge cache: %w", err)
+	}
+	return removed, nil
+}
+
+// purgeKey hashes a URL the same way handleCache keys the request for it. The
+// URL's own host wins over host, the one given alongside it.
+func (s *CachingService) purgeKey(cfg config.Config, rawURL, host string) (string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: parse url: %v", ErrInvalidPurge, err)
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	if target.Host != "" {
+		host = target.Host
+	}
+	if cfg.UsesHosts() && host == "" {
+		return "", fmt.Errorf("%w: url purges need a host once virtual hosts are configured", ErrInvalidPurge)
+	}
+
+	request := &http.Request{Method: http.MethodGet, URL: target, Host: host}
+	endpoint := cfg.EndpointFor(request.Host, request.URL.Path)
+	return CacheKey(cfg, request, endpoint), nil
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/robertomachorro/doormanlb/internal/cache"
+	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/keybuilder"
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+	"github.com/robertomachorro/doormanlb/internal/routing"
+)
+
+func TestPurgeURLUsesEndpointKeyOptions(t *testing.T) {
+	ignore := true
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCache, ExpireTimeout: config.Milliseconds(60_000)},
+			"/search":                 {IgnoreParameters: &ignore},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	ctx := context.Background()
+	store := cache.NewMemoryStore(0, 0)
+	svc := NewCachingService(cfg, router, store, &fakeFetcher{response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("ok")}})
+
+	for _, target := range []string{"http://localhost/search?q=a", "http://localhost/articles?a=1"} {
+		if err := svc.Handle(ctx, httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling %s: %v", target, err)
+		}
+	}
+
+	removed, err := svc.Purge(ctx, PurgeRequest{URL: "https://example.com/search?q=other"})
+	if err != nil {
+		t.Fatalf("purging: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected the parameter-less search entry to be purged, got %d", removed)
+	}
+
+	articleKey := keybuilder.Build(httptest.NewRequest(http.MethodGet, "http://localhost/articles?a=1", nil), keybuilder.Options{})
+	if cached, _ := store.Get(ctx, articleKey); cached == nil {
+		t.Fatal("expected unrelated entry to survive")
+	}
+
+	removed, err = svc.Purge(ctx, PurgeRequest{Prefix: "/art"})
+	if err != nil {
+		t.Fatalf("purging prefix: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected one entry under /art, got %d", removed)
+	}
+	if got := svc.Metrics()["cache_purged_keys_total"]; got != 2 {
+		t.Fatalf("expected cache_purged_keys_total=2, got %d", got)
+	}
+}
+
+func TestPurgeTagRemovesTaggedPages(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCache, ExpireTimeout: config.Milliseconds(60_000)},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	fetcher := &countingFetcher{
+		responseFn: func(request *http.Request) *proxy.Response {
+			tags := "post-42"
+			if request.URL.Path == "/about" {
+				tags = "page-about"
+			}
+			return &proxy.Response{StatusCode: http.StatusOK, Header: http.Header{"Surrogate-Key": []string{tags}}, Body: []byte("ok")}
+		},
+	}
+	ctx := context.Background()
+	store := cache.NewMemoryStore(0, 0)
+	svc := NewCachingService(cfg, router, store, fetcher)
+
+	for _, target := range []string{"http://localhost/blog/post-42", "http://localhost/", "http://localhost/about"} {
+		if err := svc.Handle(ctx, httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling %s: %v", target, err)
+		}
+	}
+
+	removed, err := svc.Purge(ctx, PurgeRequest{Tag: "post-42"})
+	if err != nil {
+		t.Fatalf("purging tag: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected both pages showing post-42 to be purged, got %d", removed)
+	}
+
+	aboutKey := keybuilder.Build(httptest.NewRequest(http.MethodGet, "http://localhost/about", nil), keybuilder.Options{})
+	if cached, _ := store.Get(ctx, aboutKey); cached == nil {
+		t.Fatal("expected untagged page to survive")
+	}
+}
+
+func TestPurgeRejectsAmbiguousRequests(t *testing.T) {
+	svc := NewCachingService(config.Config{}, nil, cache.NewMemoryStore(0, 0), nil)
+
+	for _, purge := range []PurgeRequest{{}, {URL: "/a", All: true}, {Prefix: "/a", URL: "/b"}, {Tag: "post", All: true}} {
+		if _, err := svc.Purge(context.Background(), purge); !errors.Is(err, ErrInvalidPurge) {
+			t.Fatalf("expected ErrInvalidPurge for %+v, got %v", purge, err)
+		}
+	}
+}
+
+func TestPurgeRequiresPurgeableStore(t *testing.T) {
+	svc := NewCachingService(config.Config{}, nil, &fakeStore{}, nil)
+
+	if _, err := svc.Purge(context.Background(), PurgeRequest{All: true}); err == nil {
+		t.Fatal("expected error for a store without purge support")
+	}
+}
+
+func TestPurgesAreScopedToVirtualHosts(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://web"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCache, ExpireTimeout: config.Milliseconds(60_000)},
+		},
+		Hosts: map[string]config.HostConfig{
+			"a.example.com": {Services: []config.Service{{URL: "http://site-a"}}},
+			"b.example.com": {Services: []config.Service{{URL: "http://site-b"}}},
+		},
+	}
+	routers := map[string]*routing.Router{}
+	for _, name := range cfg.PoolNames() {
+		router, err := routing.NewRouter(cfg.Pool(name).ServiceURLs(), cfg.Pool(name).Strategy)
+		if err != nil {
+			t.Fatalf("creating router for %s: %v", name, err)
+		}
+		routers[name] = router
+	}
+	fetcher := &countingFetcher{
+		responseFn: func(*http.Request) *proxy.Response {
+			return &proxy.Response{StatusCode: http.StatusOK, Header: http.Header{"Surrogate-Key": []string{"post-42"}}, Body: []byte("ok")}
+		},
+	}
+	ctx := context.Background()
+	store := cache.NewMemoryStore(0, 0)
+	svc := NewPooledCachingService(cfg, routers, store, fetcher)
+
+	seed := func() {
+		for _, target := range []string{"http://a.example.com/blog/post", "http://b.example.com/blog/post"} {
+			if err := svc.Handle(ctx, httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder()); err != nil {
+				t.Fatalf("handling %s: %v", target, err)
+			}
+		}
+	}
+	siteB := func() bool {
+		request := httptest.NewRequest(http.MethodGet, "http://b.example.com/blog/post", nil)
+		cached, _ := store.Get(ctx, keybuilder.Build(request, keybuilder.Options{Host: "b.example.com"}))
+		return cached != nil
+	}
+
+	for _, purge := range []PurgeRequest{
+		{URL: "http://A.example.com:8080/blog/post"},
+		{URL: "/blog/post", Host: "a.example.com"},
+		{Prefix: "/blog/", Host: "a.example.com"},
+		{Tag: "post-42", Host: "a.example.com"},
+	} {
+		seed()
+		removed, err := svc.Purge(ctx, purge)
+		if err != nil {
+			t.Fatalf("purging %+v: %v", purge, err)
+		}
+		if removed != 1 || !siteB() {
+			t.Fatalf("expected %+v to purge only a.example.com, removed %d", purge, removed)
+		}
+	}
+
+	seed()
+	if removed, err := svc.Purge(ctx, PurgeRequest{Tag: "post-42"}); err != nil || removed != 2 {
+		t.Fatalf("expected a host-less tag purge to cover both hosts, removed %d err %v", removed, err)
+	}
+
+	for _, purge := range []PurgeRequest{{URL: "/blog/post"}, {Prefix: "/blog/"}} {
+		if _, err := svc.Purge(ctx, purge); !errors.Is(err, ErrInvalidPurge) {
+			t.Fatalf("expected ErrInvalidPurge for host-less %+v, got %v", purge, err)
+		}
+	}
+}
@@ -1,163 +1,639 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/robertomachorro/doormanlb/internal/cache"
+	"github.com/robertomachorro/doormanlb/internal/cachecontrol"
 	"github.com/robertomachorro/doormanlb/internal/config"
 	"github.com/robertomachorro/doormanlb/internal/keybuilder"
 	"github.com/robertomachorro/doormanlb/internal/proxy"
 	"github.com/robertomachorro/doormanlb/internal/routing"
 )
 
+// ErrResponseStarted marks errors that happened after the response head was
+// written, when the response can only be cut short.
+var ErrResponseStarted = errors.New("response already started")
+
 type RequestService interface {
 	Handle(ctx context.Context, request *http.Request, writer http.ResponseWriter) error
 	Ready(ctx context.Context) error
 	Metrics() map[string]uint64
+	Purge(ctx context.Context, purge PurgeRequest) (int, error)
+	UpstreamHealth() map[string]bool
 }
 
 type responseFetcher interface {
 	Fetch(ctx context.Context, upstreamBaseURL string, request *http.Request) (*proxy.Response, error)
 }
 
+// streamOpener is implemented by fetchers that can hand back the body unread.
+type streamOpener interface {
+	Open(ctx context.Context, upstreamBaseURL string, request *http.Request) (*proxy.Stream, error)
+}
+
 type CachingService struct {
-	config config.Config
-	router *routing.Router
-	cache  cache.Store
-	proxy  responseFetcher
-	stats  serviceMetrics
+	// state holds the configuration and routers, swapped as one by Reload.
+	state      atomic.Pointer[serviceState]
+	cache      cache.Store
+	proxy      responseFetcher
+	stats      serviceMetrics
+	refreshing sync.Map
+	// flights holds the *flight resolving each cache key in this process.
+	flights     sync.Map
+	retryBudget retryBudget
+}
+
+// serviceState is one configuration with the routers of its pools. Each request
+// loads it once and resolves everything through it, so a reload never mixes two
+// configurations within a request.
+type serviceState struct {
+	config  config.Config
+	routers map[string]*routing.Router
 }
 
 const (
-	defaultLeaderLockTTL = 15 * time.Second
-	maxLeaderLockTTL     = 30 * time.Second
-	maxCacheAttempts     = 3
+	cacheStatusHeader         = "X-Cache"
+	cacheStatusStale          = "STALE"
+	warningStale              = `110 - "Response is Stale"`
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+const (
+	defaultLeaderLockTTL       = 15 * time.Second
+	maxLeaderLockTTL           = 30 * time.Second
+	defaultCacheAttempts       = 3
+	defaultCacheAttemptBackoff = 10 * time.Millisecond
 )
 
 type serviceMetrics struct {
 	requestsTotal       atomic.Uint64
 	cacheHits           atomic.Uint64
 	cacheMisses         atomic.Uint64
+	staleHits           atomic.Uint64
+	backgroundRefreshes atomic.Uint64
+	staleIfErrorHits    atomic.Uint64
 	leaderAcquired      atomic.Uint64
 	followerWaits       atomic.Uint64
 	upstreamFetches     atomic.Uint64
 	cacheSets           atomic.Uint64
 	cacheSkips5xx       atomic.Uint64
+	cacheSkipsNoStore   atomic.Uint64
+	cacheSkipsVary      atomic.Uint64
+	cachePurgedKeys     atomic.Uint64
 	cacheOperationError atomic.Uint64
 	followerTimeouts    atomic.Uint64
 	fallbackFetches     atomic.Uint64
+	requestsCoalesced   atomic.Uint64
+	upstreamRetries     atomic.Uint64
+	retryBudgetSkips    atomic.Uint64
+
+	// endpoints holds the leader protocol counters of each endpoint, by label.
+	endpoints sync.Map
+}
+
+// endpointMetrics counts how the leader protocol went for one endpoint.
+type endpointMetrics struct {
+	leaderAcquired   atomic.Uint64
+	followerWaits    atomic.Uint64
+	followerTimeouts atomic.Uint64
+	fallbackFetches  atomic.Uint64
+}
+
+// endpoint returns the counters of the endpoint with the given metric label.
+func (m *serviceMetrics) endpoint(label string) *endpointMetrics {
+	if counters, ok := m.endpoints.Load(label); ok {
+		return counters.(*endpointMetrics)
+	}
+	counters, _ := m.endpoints.LoadOrStore(label, &endpointMetrics{})
+	return counters.(*endpointMetrics)
+}
+
+// endpointLabel names the endpoint serving request in metrics, with its host
+// pattern when it belongs to a virtual host.
+func (st *serviceState) endpointLabel(request *http.Request) string {
+	host, key := st.config.MatchEndpoint(request.Host, request.URL.Path)
+	if host != "" {
+		return fmt.Sprintf("host=%q,endpoint=%q", host, key)
+	}
+	return fmt.Sprintf("endpoint=%q", key)
+}
+
+// NewCachingService serves every endpoint from a single router.
+func NewCachingService(cfg config.Config, router *routing.Router, cacheStore cache.Store, proxyClient responseFetcher) *CachingService {
+	return NewPooledCachingService(cfg, map[string]*routing.Router{config.DefaultPoolKey: router}, cacheStore, proxyClient)
+}
+
+// NewPooledCachingService routes each endpoint through the router of its pool,
+// keyed by pool name; endpoints whose pool has no router use the DEFAULT one.
+func NewPooledCachingService(cfg config.Config, routers map[string]*routing.Router, cacheStore cache.Store, proxyClient responseFetcher) *CachingService {
+	service := &CachingService{
+		cache: cacheStore,
+		proxy: proxyClient,
+	}
+	service.Reload(cfg, routers)
+	return service
+}
+
+// Reload swaps in a new configuration and the routers of its pools. Requests
+// already being served finish with the configuration they started with.
+func (s *CachingService) Reload(cfg config.Config, routers map[string]*routing.Router) {
+	s.state.Store(&serviceState{config: cfg, routers: routers})
+}
+
+func (s *CachingService) current() *serviceState {
+	return s.state.Load()
 }
 
-func NewCachingService(config config.Config, router *routing.Router, cacheStore cache.Store, proxyClient responseFetcher) *CachingService {
-	return &CachingService{
-		config: config,
-		router: router,
-		cache:  cacheStore,
-		proxy:  proxyClient,
+// endpointFor resolves the endpoint configuration of request by host and path.
+func (st *serviceState) endpointFor(request *http.Request) config.EndpointConfig {
+	return st.config.EndpointFor(request.Host, request.URL.Path)
+}
+
+func (st *serviceState) cacheKey(request *http.Request, endpoint config.EndpointConfig) string {
+	return CacheKey(st.config, request, endpoint)
+}
+
+// CacheKey identifies the page request asks for. The host is part of it once
+// virtual hosts are configured.
+func CacheKey(cfg config.Config, request *http.Request, endpoint config.EndpointConfig) string {
+	options := keybuilder.Options{IgnoreParameters: endpoint.ShouldIgnoreParameters(), Host: cacheHost(cfg, request.Host)}
+	return keybuilder.Build(request, options)
+}
+
+// cacheHost is the host cached pages are keyed and purged by: the normalized
+// Host header once virtual hosts are configured, and empty otherwise.
+func cacheHost(cfg config.Config, host string) string {
+	if !cfg.UsesHosts() {
+		return ""
 	}
+	return config.NormalizeHost(host)
+}
+
+// routerFor returns the router of the pool serving endpoint.
+func (st *serviceState) routerFor(endpoint config.EndpointConfig) *routing.Router {
+	if router, ok := st.routers[endpoint.Pool]; ok {
+		return router
+	}
+	return st.routers[config.DefaultPoolKey]
 }
 
 func (s *CachingService) Handle(ctx context.Context, request *http.Request, writer http.ResponseWriter) error {
 	s.stats.requestsTotal.Add(1)
-	endpoint := s.config.Endpoint(request.URL.Path)
+	state := s.current()
+	endpoint := state.endpointFor(request)
 
 	switch endpoint.CacheBehavior {
 	case config.CacheBehaviorPassthrough:
-		return s.fetchAndWrite(ctx, request, writer)
+		return s.fetchAndWrite(ctx, state, request, writer)
 	case config.CacheBehaviorCache:
-		return s.handleCache(ctx, request, writer, endpoint)
+		return s.handleCache(ctx, state, request, writer, endpoint)
+	case config.CacheBehaviorCoalesce:
+		return s.handleCoalesce(ctx, state, request, writer, endpoint)
 	default:
 		return fmt.Errorf("unsupported cache behavior %q", endpoint.CacheBehavior)
 	}
 }
 
-func (s *CachingService) handleCache(ctx context.Context, request *http.Request, writer http.ResponseWriter, endpoint config.EndpointConfig) error {
+// cacheTarget identifies where the response for a request lives in the store.
+type cacheTarget struct {
+	// state is the configuration the request started with.
+	state    *serviceState
+	endpoint config.EndpointConfig
+	// baseKey is the keybuilder hash of the request.
+	baseKey string
+	// key is baseKey, or the variant key selected by the request's headers when
+	// the cached response varies on them.
+	key string
+	// vary lists the headers that selected key, if it is a variant key.
+	vary    []string
+	lockTTL time.Duration
+	metrics *endpointMetrics
+}
+
+func (s *CachingService) handleCache(ctx context.Context, state *serviceState, request *http.Request, writer http.ResponseWriter, endpoint config.EndpointConfig) (err error) {
 	if s.cache == nil {
-		return errors.New("cache behavior requires redis store")
+		return errors.New("cache behavior requires a cache store")
 	}
 
-	cacheKey := keybuilder.Build(request, keybuilder.Options{IgnoreParameters: endpoint.ShouldIgnoreParameters()})
-	ttl := endpoint.CacheTTL()
-	lockTTL := leaderLockTTL(ttl)
+	cacheKey := state.cacheKey(request, endpoint)
+	target := &cacheTarget{
+		state:    state,
+		endpoint: endpoint,
+		baseKey:  cacheKey,
+		key:      cacheKey,
+		lockTTL:  LockTTL(endpoint),
+		metrics:  s.stats.endpoint(state.endpointLabel(request)),
+	}
 
-	for attempts := 0; attempts < maxCacheAttempts; attempts++ {
-		cachedResponse, err := s.cache.Get(ctx, cacheKey)
+	// Time spent waiting on a leader in this process counts against the same
+	// budget as waiting on leaders elsewhere.
+	waitDeadline := time.Now().Add(FollowerMaxWait(endpoint))
+
+	// Only one request per key and process takes part in the distributed protocol;
+	// identical requests arriving meanwhile share its response.
+	inFlight, release, err := s.coalesce(ctx, request, writer, cacheKey, waitDeadline)
+	if inFlight == nil {
+		return err
+	}
+	defer func() { release(err) }()
+
+	// stale holds an expired entry that may still be served if the upstream fails.
+	var stale *cache.Entry
+	leaderFinished := false
+
+	for attempts := 0; attempts < CacheAttempts(endpoint); attempts++ {
+		entry, err := s.lookup(ctx, request, target)
 		if err != nil {
 			s.stats.cacheOperationError.Add(1)
 			return err
 		}
-		if cachedResponse != nil {
-			s.stats.cacheHits.Add(1)
-			cachedResponse.WriteTo(writer)
-			return nil
+		if entry != nil {
+			now := time.Now()
+			if entry.IsFresh(now) {
+				s.stats.cacheHits.Add(1)
+				serve(writer, inFlight, request, entry.Response, target.vary)
+				return nil
+			}
+			if now.Before(entry.FreshUntil.Add(endpoint.StaleWhileRevalidateWindow())) {
+				s.stats.staleHits.Add(1)
+				s.refreshInBackground(request, target)
+				serve(writer, inFlight, request, staleResponse(entry, warningStale), target.vary)
+				return nil
+			}
+			if now.Before(entry.FreshUntil.Add(endpoint.StaleIfErrorWindow())) {
+				stale = entry
+				if leaderFinished {
+					// The leader we waited on could not refresh the entry.
+					s.stats.staleIfErrorHits.Add(1)
+					serve(writer, inFlight, request, staleResponse(stale, warningRevalidationFailed), target.vary)
+					return nil
+				}
+			}
 		}
 		s.stats.cacheMisses.Add(1)
 
-		lock, acquired, err := s.cache.TryAcquireLeader(ctx, cacheKey, lockTTL)
+		lock, acquired, err := s.cache.TryAcquireLeader(ctx, target.key, target.lockTTL)
 		if err != nil {
 			s.stats.cacheOperationError.Add(1)
 			return err
 		}
 		if acquired {
 			s.stats.leaderAcquired.Add(1)
-			return s.handleAsLeader(ctx, request, writer, cacheKey, ttl, lock)
+			target.metrics.leaderAcquired.Add(1)
+			return s.handleAsLeader(ctx, request, writer, target, inFlight, lock, stale)
 		}
 
-		// A winner already exists. Wait for completion, then retry cache read.
+		// A winner already exists. Wait for completion, then retry cache read,
+		// unless the follower has already waited as long as it may.
+		wait := min(time.Until(waitDeadline), target.lockTTL)
+		if wait <= 0 {
+			break
+		}
 		s.stats.followerWaits.Add(1)
-		err = s.cache.WaitForDone(ctx, cacheKey, lockTTL)
+		target.metrics.followerWaits.Add(1)
+		err = s.cache.WaitForDone(ctx, target.key, wait)
 		if err != nil && !errors.Is(err, cache.ErrWaitTimeout) {
 			s.stats.cacheOperationError.Add(1)
 			return err
 		}
 		if errors.Is(err, cache.ErrWaitTimeout) {
 			s.stats.followerTimeouts.Add(1)
-			if sleepErr := sleepBackoff(ctx, attempts); sleepErr != nil {
+			target.metrics.followerTimeouts.Add(1)
+			if sleepErr := sleepBackoff(ctx, CacheAttemptBackoff(endpoint, attempts)); sleepErr != nil {
 				return sleepErr
 			}
+			continue
 		}
+		leaderFinished = true
 	}
 
 	// Fallback to direct upstream response if lock/wait retries were inconclusive.
 	s.stats.fallbackFetches.Add(1)
-	return s.fetchAndWrite(ctx, request, writer)
+	target.metrics.fallbackFetches.Add(1)
+	upstreamResponse, err := s.fetchFromUpstream(ctx, state, request)
+	if stale != nil && upstreamFailed(upstreamResponse, err) {
+		s.stats.staleIfErrorHits.Add(1)
+		serve(writer, inFlight, request, staleResponse(stale, warningRevalidationFailed), target.vary)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	vary, shareable := sharing(upstreamResponse.Header)
+	inFlight.start(upstreamResponse, vary, request, shareable)
+	inFlight.append(upstreamResponse.Body)
+	inFlight.finish(nil)
+	upstreamResponse.WriteTo(writer)
+	return nil
 }
 
-func (s *CachingService) handleAsLeader(ctx context.Context, request *http.Request, writer http.ResponseWriter, cacheKey string, ttl time.Duration, lock *cache.Lock) error {
-	defer func() {
-		cleanupCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		_ = s.cache.PublishDone(cleanupCtx, cacheKey)
-		_ = s.cache.ReleaseLeader(cleanupCtx, lock)
-	}()
+// lookup reads the cached entry for a request, following a variant manifest to
+// the representation selected by the request's headers. It updates target.key
+// and target.vary to the key that was ultimately read.
+func (s *CachingService) lookup(ctx context.Context, request *http.Request, target *cacheTarget) (*cache.Entry, error) {
+	target.key = target.baseKey
+	target.vary = nil
+	entry, err := s.cache.Get(ctx, target.baseKey)
+	if err != nil || entry == nil || entry.Response != nil {
+		return entry, err
+	}
+	if !entry.IsVariantManifest() || !target.endpoint.ShouldHonorVary() {
+		return nil, nil
+	}
 
-	upstreamResponse, err := s.fetchFromUpstream(ctx, request)
+	target.key = keybuilder.Variant(target.baseKey, request, entry.Vary)
+	target.vary = entry.Vary
+	return s.cache.Get(ctx, target.key)
+}
+
+func (s *CachingService) handleAsLeader(ctx context.Context, request *http.Request, writer http.ResponseWriter, target *cacheTarget, inFlight *flight, lock *cache.Lock, stale *cache.Entry) error {
+	defer s.finishLeadership(target.key, lock)
+
+	// The fetch is shared with coalesced requests and the cache, so it outlives
+	// the leader's client; only the writes to that client stop when it leaves.
+	ctx = context.WithoutCancel(ctx)
+	stream, cancel, err := s.openDetached(ctx, target.state, request, target.lockTTL)
+	defer cancel()
+	if stale != nil && (err != nil || !shouldCache(stream.StatusCode)) {
+		if err == nil {
+			_ = stream.Body.Close()
+		}
+		s.stats.staleIfErrorHits.Add(1)
+		serve(writer, inFlight, request, staleResponse(stale, warningRevalidationFailed), target.vary)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	defer stream.Body.Close()
 
-	if shouldCache(upstreamResponse.StatusCode) {
-		if err := s.cache.Set(ctx, cacheKey, upstreamResponse, ttl); err != nil {
+	head := &proxy.Response{StatusCode: stream.StatusCode, Header: stream.Header}
+	plan, cacheable := s.planStorage(target, head)
+	inFlight.start(head, plan.vary, request, cacheable)
+
+	head.WriteHeader(writer)
+	body, err := relay(stream.Body, writer, inFlight)
+	if err != nil {
+		inFlight.finish(err)
+		return fmt.Errorf("%w: streaming upstream response: %w", ErrResponseStarted, err)
+	}
+	inFlight.finish(nil)
+
+	if cacheable {
+		s.storeResponse(ctx, request, target, plan, &proxy.Response{StatusCode: head.StatusCode, Header: head.Header, Body: body})
+	}
+	return nil
+}
+
+// errHeaderTimeout is the cause of a detached fetch cancelled because its
+// response headers did not arrive in time.
+var errHeaderTimeout = errors.New("upstream response headers timed out")
+
+// openDetached opens an upstream response that does not end with the client that
+// started it. Only the wait for the response headers is bounded, by limit, as a
+// stalled upstream would otherwise hold its flight, and every request joining it,
+// forever; the body then takes as long as it needs. The caller cancels the fetch
+// when done with it, whether or not it succeeded.
+func (s *CachingService) openDetached(ctx context.Context, state *serviceState, request *http.Request, limit time.Duration) (*proxy.Stream, context.CancelFunc, error) {
+	fetchCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	timer := time.AfterFunc(limit, func() { cancel(errHeaderTimeout) })
+	stream, err := s.openUpstream(fetchCtx, state, request)
+	timer.Stop()
+	if err != nil && errors.Is(context.Cause(fetchCtx), errHeaderTimeout) {
+		err = fmt.Errorf("%w: %w", errHeaderTimeout, err)
+	}
+	return stream, func() { cancel(nil) }, err
+}
+
+// handleCoalesce shares one upstream fetch between identical requests that are
+// in progress at the same time in this process, without storing the response.
+func (s *CachingService) handleCoalesce(ctx context.Context, state *serviceState, request *http.Request, writer http.ResponseWriter, endpoint config.EndpointConfig) (err error) {
+	if hasCredentials(request) {
+		// The key does not tell users apart, so their requests are never shared.
+		return s.fetchAndWrite(ctx, state, request, writer)
+	}
+	key := state.cacheKey(request, endpoint)
+	inFlight, release, err := s.coalesce(ctx, request, writer, key, time.Now().Add(FollowerMaxWait(endpoint)))
+	if inFlight == nil {
+		return err
+	}
+	defer func() { release(err) }()
+
+	// As with a cache leader, the shared fetch does not end when this client leaves.
+	stream, cancel, err := s.openDetached(ctx, state, request, LockTTL(endpoint))
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	defer stream.Body.Close()
+
+	head := &proxy.Response{StatusCode: stream.StatusCode, Header: stream.Header}
+	vary, shareable := sharing(head.Header)
+	inFlight.start(head, vary, request, shareable)
+
+	head.WriteHeader(writer)
+	if _, err := relay(stream.Body, writer, inFlight); err != nil {
+		inFlight.finish(err)
+		return fmt.Errorf("%w: streaming upstream response: %w", ErrResponseStarted, err)
+	}
+	return nil
+}
+
+// hasCredentials reports whether request identifies its user, whose response
+// must not be handed to anyone else.
+func hasCredentials(request *http.Request) bool {
+	return request.Header.Get("Authorization") != "" || request.Header.Get("Cookie") != ""
+}
+
+// sharing reports on which request headers a response varies, and whether it
+// may be handed to the requests coalesced with the one that fetched it.
+func sharing(header http.Header) (vary []string, shareable bool) {
+	vary, wildcard := cachecontrol.VaryHeaders(header)
+	return vary, !wildcard && cachecontrol.Shareable(header)
+}
+
+// coalesce registers a flight resolving key in this process, or shares the
+// response of the one already registered with request if it starts before
+// deadline. It returns a nil flight once the request has been answered (or
+// failed); otherwise the caller resolves it through the returned flight and
+// calls release with the request's outcome when done.
+func (s *CachingService) coalesce(ctx context.Context, request *http.Request, writer http.ResponseWriter, key string, deadline time.Time) (*flight, func(error), error) {
+	inFlight := newFlight()
+	existing, loaded := s.flights.LoadOrStore(key, inFlight)
+	if !loaded {
+		return inFlight, func(err error) {
+			// Coalesced requests share the failure of a request that produced
+			// no response, rather than all retrying it at once. If it only
+			// failed because its client left, or produced no shareable
+			// response, they resolve the request on their own.
+			if ctx.Err() != nil {
+				err = nil
+			}
+			inFlight.finish(err)
+			s.flights.CompareAndDelete(key, inFlight)
+		}, nil
+	}
+
+	if shared, err := s.followFlight(ctx, request, writer, existing.(*flight), deadline); shared || err != nil {
+		return nil, nil, err
+	}
+	// The response cannot be shared with this request, or did not start in
+	// time; resolve it on its own through an unregistered flight.
+	return inFlight, func(error) { inFlight.finish(nil) }, nil
+}
+
+// followFlight waits until deadline for the response of an identical request
+// being resolved by this process and streams it to writer. It reports false when
+// that response cannot be shared with request or has not started by deadline.
+func (s *CachingService) followFlight(ctx context.Context, request *http.Request, writer http.ResponseWriter, inFlight *flight, deadline time.Time) (bool, error) {
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	shared, err := inFlight.accepts(waitCtx, request)
+	if errors.Is(err, errLeaderFailed) {
+		s.stats.requestsCoalesced.Add(1)
+		return false, err
+	}
+	if err != nil && ctx.Err() == nil {
+		s.stats.followerTimeouts.Add(1)
+		return false, nil
+	}
+	if err != nil || !shared {
+		return false, err
+	}
+
+	s.stats.requestsCoalesced.Add(1)
+	if err := inFlight.streamTo(ctx, writer); err != nil {
+		return true, fmt.Errorf("%w: streaming shared response: %w", ErrResponseStarted, err)
+	}
+	return true, nil
+}
+
+// refreshInBackground re-fetches a stale entry without blocking the caller. The
+// leader lock ensures only one replica refreshes a given key at a time.
+func (s *CachingService) refreshInBackground(request *http.Request, target *cacheTarget) {
+	cacheKey := target.key
+	if _, running := s.refreshing.LoadOrStore(cacheKey, struct{}{}); running {
+		return
+	}
+	refreshRequest := request.Clone(context.Background())
+	refreshTarget := *target
+
+	go func() {
+		defer s.refreshing.Delete(cacheKey)
+
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTarget.lockTTL)
+		defer cancel()
+
+		lock, acquired, err := s.cache.TryAcquireLeader(ctx, cacheKey, refreshTarget.lockTTL)
+		if err != nil {
 			s.stats.cacheOperationError.Add(1)
-			// Best effort: serve the response even if cache storage fails.
-		} else {
-			s.stats.cacheSets.Add(1)
+			return
+		}
+		if !acquired {
+			return
+		}
+		s.stats.backgroundRefreshes.Add(1)
+		defer s.finishLeadership(cacheKey, lock)
+
+		upstreamResponse, err := s.fetchFromUpstream(ctx, refreshTarget.state, refreshRequest)
+		if err != nil {
+			return
 		}
-	} else {
+		if plan, ok := s.planStorage(&refreshTarget, upstreamResponse); ok {
+			s.storeResponse(ctx, refreshRequest, &refreshTarget, plan, upstreamResponse)
+		}
+	}()
+}
+
+func (s *CachingService) finishLeadership(cacheKey string, lock *cache.Lock) {
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = s.cache.PublishDone(cleanupCtx, cacheKey)
+	_ = s.cache.ReleaseLeader(cleanupCtx, lock)
+}
+
+// storagePlan is how an upstream response will be cached, decided from its
+// status and headers alone so it is known before the body is read.
+type storagePlan struct {
+	ttl        time.Duration
+	freshUntil time.Time
+	// vary lists the request headers selecting this representation, if any.
+	vary []string
+	tags []string
+}
+
+// planStorage decides whether a response may be cached, counting the reason
+// when it may not.
+func (s *CachingService) planStorage(target *cacheTarget, head *proxy.Response) (storagePlan, bool) {
+	now := time.Now()
+	if !shouldCache(head.StatusCode) {
 		s.stats.cacheSkips5xx.Add(1)
+		return storagePlan{}, false
 	}
 
-	upstreamResponse.WriteTo(writer)
-	return nil
+	ttl, ok := responseTTL(target.endpoint, head.Header, now)
+	if !ok {
+		s.stats.cacheSkipsNoStore.Add(1)
+		return storagePlan{}, false
+	}
+
+	plan := storagePlan{ttl: ttl, freshUntil: now.Add(ttl), tags: cachecontrol.SurrogateKeys(head.Header)}
+	if target.endpoint.ShouldHonorVary() {
+		vary, wildcard := cachecontrol.VaryHeaders(head.Header)
+		if wildcard || !target.endpoint.AllowsVaryOn(vary) {
+			s.stats.cacheSkipsVary.Add(1)
+			return storagePlan{}, false
+		}
+		plan.vary = vary
+	}
+	return plan, true
+}
+
+func (s *CachingService) storeResponse(ctx context.Context, request *http.Request, target *cacheTarget, plan storagePlan, upstreamResponse *proxy.Response) {
+	entry := &cache.Entry{
+		Response:   upstreamResponse,
+		FreshUntil: plan.freshUntil,
+		Host:       cacheHost(target.state.config, request.Host),
+		Path:       request.URL.Path,
+		Tags:       plan.tags,
+	}
+	// Keep the entry past its freshness lifetime so it can be served stale.
+	retention := plan.ttl + target.endpoint.StaleRetention()
+
+	var manifest *cache.Entry
+	key := target.baseKey
+	if len(plan.vary) > 0 {
+		manifest = &cache.Entry{FreshUntil: entry.FreshUntil, Vary: plan.vary, Host: entry.Host, Path: entry.Path, Tags: entry.Tags}
+		key = keybuilder.Variant(target.baseKey, request, plan.vary)
+	}
+
+	if err := s.cache.Set(ctx, key, entry, retention); err != nil {
+		s.stats.cacheOperationError.Add(1)
+		// Best effort: serve the response even if cache storage fails.
+		return
+	}
+	// The manifest is written after the variant so readers never follow it to a missing key.
+	if manifest != nil {
+		if err := s.cache.Set(ctx, target.baseKey, manifest, retention); err != nil {
+			s.stats.cacheOperationError.Add(1)
+			return
+		}
+	}
+	s.stats.cacheSets.Add(1)
 }
 
-func (s *CachingService) fetchAndWrite(ctx context.Context, request *http.Request, writer http.ResponseWriter) error {
-	upstreamResponse, err := s.fetchFromUpstream(ctx, request)
+func (s *CachingService) fetchAndWrite(ctx context.Context, state *serviceState, request *http.Request, writer http.ResponseWriter) error {
+	upstreamResponse, err := s.fetchFromUpstream(ctx, state, request)
 	if err != nil {
 		return err
 	}
@@ -165,17 +641,88 @@ func (s *CachingService) fetchAndWrite(ctx context.Context, request *http.Reques
 	return nil
 }
 
-func (s *CachingService) fetchFromUpstream(ctx context.Context, request *http.Request) (*proxy.Response, error) {
-	s.stats.upstreamFetches.Add(1)
-	lease := s.router.Acquire()
-	defer lease.Release()
+// fetchFromUpstream fetches the whole response, retrying on other services as
+// the retry policy allows.
+func (s *CachingService) fetchFromUpstream(ctx context.Context, state *serviceState, request *http.Request) (*proxy.Response, error) {
+	upstream := s.beginUpstream(ctx, state, request)
+	defer upstream.done()
+
+	for {
+		try := upstream.next()
+		response, err := s.proxy.Fetch(try.ctx, try.lease.URL, request)
+		try.lease.Report(statusOf(response), err)
+		try.end()
+		if !upstream.shouldRetry(statusOf(response), err) {
+			return response, err
+		}
+	}
+}
+
+func statusOf(response *proxy.Response) int {
+	if response == nil {
+		return 0
+	}
+	return response.StatusCode
+}
+
+// openUpstream starts an upstream request for the leader to stream, retrying on
+// other services until response headers worth keeping arrive. The router lease
+// is held until the body is closed.
+func (s *CachingService) openUpstream(ctx context.Context, state *serviceState, request *http.Request) (*proxy.Stream, error) {
+	opener, ok := s.proxy.(streamOpener)
+	if !ok {
+		response, err := s.fetchFromUpstream(ctx, state, request)
+		if err != nil {
+			return nil, err
+		}
+		return &proxy.Stream{
+			StatusCode: response.StatusCode,
+			Header:     response.Header,
+			Body:       io.NopCloser(bytes.NewReader(response.Body)),
+		}, nil
+	}
+
+	upstream := s.beginUpstream(ctx, state, request)
+	defer upstream.done()
+
+	for {
+		try := upstream.next()
+		stream, err := opener.Open(try.ctx, try.lease.URL, request)
+		if err != nil {
+			try.lease.Report(0, err)
+			try.end()
+			if upstream.shouldRetry(0, err) {
+				continue
+			}
+			return nil, err
+		}
+		try.lease.Report(stream.StatusCode, nil)
+		if upstream.shouldRetry(stream.StatusCode, nil) {
+			_ = stream.Body.Close()
+			try.end()
+			continue
+		}
+		stream.Body = &leasedBody{ReadCloser: stream.Body, try: try}
+		return stream, nil
+	}
+}
+
+type leasedBody struct {
+	io.ReadCloser
+	try *upstreamTry
+}
 
-	return s.proxy.Fetch(ctx, lease.URL, request)
+func (b *leasedBody) Close() error {
+	defer b.try.end()
+	return b.ReadCloser.Close()
 }
 
+// Ready reports whether this process can serve requests. Upstream health is left
+// out: during an upstream outage every replica would otherwise drop out of
+// rotation at once, along with the stale content it could still serve.
 func (s *CachingService) Ready(ctx context.Context) error {
-	if s.config.UsesCache() && s.cache == nil {
-		return errors.New("cache configured but redis store is not initialized")
+	if s.current().config.UsesCache() && s.cache == nil {
+		return errors.New("cache configured but cache store is not initialized")
 	}
 	if checker, ok := s.cache.(interface{ Ping(context.Context) error }); ok {
 		return checker.Ping(ctx)
@@ -184,19 +731,104 @@ func (s *CachingService) Ready(ctx context.Context) error {
 }
 
 func (s *CachingService) Metrics() map[string]uint64 {
-	return map[string]uint64{
-		"requests_total":          s.stats.requestsTotal.Load(),
-		"cache_hits_total":        s.stats.cacheHits.Load(),
-		"cache_misses_total":      s.stats.cacheMisses.Load(),
-		"leader_acquired_total":   s.stats.leaderAcquired.Load(),
-		"follower_waits_total":    s.stats.followerWaits.Load(),
-		"upstream_fetches_total":  s.stats.upstreamFetches.Load(),
-		"cache_sets_total":        s.stats.cacheSets.Load(),
-		"cache_skips_5xx_total":   s.stats.cacheSkips5xx.Load(),
-		"cache_errors_total":      s.stats.cacheOperationError.Load(),
-		"follower_timeouts_total": s.stats.followerTimeouts.Load(),
-		"fallback_fetches_total":  s.stats.fallbackFetches.Load(),
+	metrics := map[string]uint64{
+		"requests_total":             s.stats.requestsTotal.Load(),
+		"cache_hits_total":           s.stats.cacheHits.Load(),
+		"cache_misses_total":         s.stats.cacheMisses.Load(),
+		"stale_hits_total":           s.stats.staleHits.Load(),
+		"background_refreshes_total": s.stats.backgroundRefreshes.Load(),
+		"stale_if_error_hits_total":  s.stats.staleIfErrorHits.Load(),
+		"leader_acquired_total":      s.stats.leaderAcquired.Load(),
+		"follower_waits_total":       s.stats.followerWaits.Load(),
+		"upstream_fetches_total":     s.stats.upstreamFetches.Load(),
+		"cache_sets_total":           s.stats.cacheSets.Load(),
+		"cache_skips_5xx_total":      s.stats.cacheSkips5xx.Load(),
+		"cache_skips_no_store_total": s.stats.cacheSkipsNoStore.Load(),
+		"cache_skips_vary_total":     s.stats.cacheSkipsVary.Load(),
+		"cache_purged_keys_total":    s.stats.cachePurgedKeys.Load(),
+		"cache_errors_total":         s.stats.cacheOperationError.Load(),
+		"follower_timeouts_total":    s.stats.followerTimeouts.Load(),
+		"fallback_fetches_total":     s.stats.fallbackFetches.Load(),
+		"requests_coalesced_total":   s.stats.requestsCoalesced.Load(),
+		"upstream_retries_total":     s.stats.upstreamRetries.Load(),
+		"retry_budget_skips_total":   s.stats.retryBudgetSkips.Load(),
+	}
+
+	s.stats.endpoints.Range(func(label, counters any) bool {
+		endpoint := counters.(*endpointMetrics)
+		metrics[fmt.Sprintf("endpoint_leader_acquired_total{%s}", label)] = endpoint.leaderAcquired.Load()
+		metrics[fmt.Sprintf("endpoint_follower_waits_total{%s}", label)] = endpoint.followerWaits.Load()
+		metrics[fmt.Sprintf("endpoint_follower_timeouts_total{%s}", label)] = endpoint.followerTimeouts.Load()
+		metrics[fmt.Sprintf("endpoint_fallback_fetches_total{%s}", label)] = endpoint.fallbackFetches.Load()
+		return true
+	})
+
+	for _, router := range s.current().routers {
+		if router == nil {
+			continue
+		}
+		for name, value := range router.Metrics() {
+			metrics[name] = value
+		}
+	}
+
+	if reporter, ok := s.cache.(interface{ Metrics() map[string]uint64 }); ok {
+		for name, value := range reporter.Metrics() {
+			metrics[name] = value
+		}
 	}
+
+	return metrics
+}
+
+// UpstreamHealth reports whether each upstream service is in rotation, by URL.
+// A service shared by several pools is healthy if any of them has it in rotation.
+func (s *CachingService) UpstreamHealth() map[string]bool {
+	var health map[string]bool
+	for _, router := range s.current().routers {
+		if router == nil {
+			continue
+		}
+		if health == nil {
+			health = make(map[string]bool)
+		}
+		for serviceURL, healthy := range router.Health() {
+			health[serviceURL] = health[serviceURL] || healthy
+		}
+	}
+	return health
+}
+
+// LockTTL is how long the leader fetching a page for endpoint holds its lock,
+// and so the longest a follower waits for it at a time. Unless the endpoint sets
+// lockTTL, it follows the cache TTL within 15 to 30 seconds.
+func LockTTL(endpoint config.EndpointConfig) time.Duration {
+	return endpoint.LockTTL.Positive(leaderLockTTL(endpoint.CacheTTL()))
+}
+
+// FollowerMaxWait is how long a follower waits on leaders in all before fetching
+// the page itself. It defaults to a lock TTL for each attempt.
+func FollowerMaxWait(endpoint config.EndpointConfig) time.Duration {
+	return endpoint.FollowerMaxWait.Positive(time.Duration(CacheAttempts(endpoint)) * LockTTL(endpoint))
+}
+
+// CacheAttempts is how many times a request for endpoint looks up the cache and
+// tries to become the leader before fetching the page itself.
+func CacheAttempts(endpoint config.EndpointConfig) int {
+	if endpoint.CacheAttempts > 0 {
+		return endpoint.CacheAttempts
+	}
+	return defaultCacheAttempts
+}
+
+// CacheAttemptBackoff is the pause after a follower times out on its attempt-th
+// try, which grows linearly from the endpoint's cacheAttemptBackoff.
+func CacheAttemptBackoff(endpoint config.EndpointConfig, attempt int) time.Duration {
+	backoff := defaultCacheAttemptBackoff
+	if endpoint.CacheAttemptBackoff.IsSet() {
+		backoff = endpoint.CacheAttemptBackoff.Duration()
+	}
+	return time.Duration(attempt+1) * backoff
 }
 
 func leaderLockTTL(cacheTTL time.Duration) time.Duration {
@@ -216,8 +848,54 @@ func shouldCache(statusCode int) bool {
 	return statusCode < http.StatusInternalServerError
 }
 
-func sleepBackoff(ctx context.Context, attempt int) error {
-	backoff := time.Duration(attempt+1) * 10 * time.Millisecond
+func upstreamFailed(response *proxy.Response, err error) bool {
+	return err != nil || !shouldCache(response.StatusCode)
+}
+
+// staleResponse copies an expired entry, flagging it as stale for the client.
+func staleResponse(entry *cache.Entry, warning string) *proxy.Response {
+	response := entry.Response.Clone()
+	response.Header.Set(cacheStatusHeader, cacheStatusStale)
+	response.Header.Add("Warning", warning)
+	return response
+}
+
+// serve writes a complete response and shares it with requests coalesced onto inFlight.
+func serve(writer http.ResponseWriter, inFlight *flight, request *http.Request, response *proxy.Response, vary []string) {
+	inFlight.start(response, vary, request, true)
+	inFlight.append(response.Body)
+	inFlight.finish(nil)
+	response.WriteTo(writer)
+}
+
+// responseTTL resolves how long an upstream response may be cached. It reports
+// false when the upstream forbids shared caching or the response is already stale.
+func responseTTL(endpoint config.EndpointConfig, header http.Header, now time.Time) (time.Duration, bool) {
+	ttl := endpoint.CacheTTL()
+	if !endpoint.UsesUpstreamTTL() {
+		return ttl, true
+	}
+
+	policy := cachecontrol.Parse(header, now)
+	if policy.NoStore {
+		return 0, false
+	}
+	if !policy.HasLifetime {
+		return ttl, true
+	}
+	if policy.Lifetime <= 0 {
+		return 0, false
+	}
+	if endpoint.TTLMode == config.TTLModeUpstreamCapped && policy.Lifetime > ttl {
+		return ttl, true
+	}
+	return policy.Lifetime, true
+}
+
+func sleepBackoff(ctx context.Context, backoff time.Duration) error {
+	if backoff <= 0 || ctx.Err() != nil {
+		return ctx.Err()
+	}
 	timer := time.NewTimer(backoff)
 	defer timer.Stop()
 	select {
@@ -2,9 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -18,17 +21,17 @@ import (
 
 func TestConcurrentIdenticalRequestsSingleFlight(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 30_000,
+				ExpireTimeout: config.Milliseconds(30_000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -76,17 +79,17 @@ func TestConcurrentIdenticalRequestsSingleFlight(t *testing.T) {
 
 func TestConcurrentDifferentKeysFetchIndependently(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 30_000,
+				ExpireTimeout: config.Milliseconds(30_000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -150,33 +153,33 @@ func (f *countingFetcher) Fetch(_ context.Context, _ string, request *http.Reque
 
 type memoryStore struct {
 	mu      sync.Mutex
-	values  map[string]*proxy.Response
+	values  map[string]*cache.Entry
 	locks   map[string]string
 	waiters map[string][]chan struct{}
 }
 
 func newMemoryStore() *memoryStore {
 	return &memoryStore{
-		values:  make(map[string]*proxy.Response),
+		values:  make(map[string]*cache.Entry),
 		locks:   make(map[string]string),
 		waiters: make(map[string][]chan struct{}),
 	}
 }
 
-func (m *memoryStore) Get(_ context.Context, key string) (*proxy.Response, error) {
+func (m *memoryStore) Get(_ context.Context, key string) (*cache.Entry, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	response := m.values[key]
-	if response == nil {
+	entry := m.values[key]
+	if entry == nil {
 		return nil, nil
 	}
-	return cloneResponse(response), nil
+	return entry.Clone(), nil
 }
 
-func (m *memoryStore) Set(_ context.Context, key string, response *proxy.Response, _ time.Duration) error {
+func (m *memoryStore) Set(_ context.Context, key string, entry *cache.Entry, _ time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.values[key] = cloneResponse(response)
+	m.values[key] = entry.Clone()
 	return nil
 }
 
@@ -237,18 +240,6 @@ func (m *memoryStore) Ping(context.Context) error {
 	return nil
 }
 
-func cloneResponse(response *proxy.Response) *proxy.Response {
-	header := make(http.Header, len(response.Header))
-	for key, values := range response.Header {
-		header[key] = append([]string(nil), values...)
-	}
-	return &proxy.Response{
-		StatusCode: response.StatusCode,
-		Header:     header,
-		Body:       append([]byte(nil), response.Body...),
-	}
-}
-
 type statusError struct {
 	code int
 }
@@ -264,3 +255,672 @@ type bodyError struct {
 func (b *bodyError) Error() string {
 	return "unexpected body " + b.body
 }
+
+func TestConcurrentIdenticalRequestsShareLeaderStreamInProcess(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(30_000),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	store := &storeCallCounter{Store: newMemoryStore()}
+	svc := NewCachingService(cfg, router, store, fetcher)
+
+	handle := func(rec *httptest.ResponseRecorder) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/downloads/big", nil)
+			done <- svc.Handle(context.Background(), req, rec)
+		}()
+		return done
+	}
+
+	leaderRec := httptest.NewRecorder()
+	leaderDone := handle(leaderRec)
+	if _, err := bodyWriter.Write([]byte("first ")); err != nil {
+		t.Fatalf("writing first chunk: %v", err)
+	}
+
+	const followers = 5
+	recorders := []*httptest.ResponseRecorder{leaderRec}
+	dones := []<-chan error{leaderDone}
+	for i := 0; i < followers; i++ {
+		rec := httptest.NewRecorder()
+		recorders = append(recorders, rec)
+		dones = append(dones, handle(rec))
+	}
+	waitFor(t, func() bool { return svc.Metrics()["requests_coalesced_total"] == followers })
+
+	if _, err := bodyWriter.Write([]byte("second")); err != nil {
+		t.Fatalf("writing second chunk: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	for i, done := range dones {
+		if err := <-done; err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		if body := recorders[i].Body.String(); body != "first second" {
+			t.Fatalf("unexpected body %q for request %d", body, i)
+		}
+	}
+
+	if fetcher.count.Load() != 1 {
+		t.Fatalf("expected a single upstream request, got %d", fetcher.count.Load())
+	}
+	if store.gets.Load() != 1 || store.acquires.Load() != 1 {
+		t.Fatalf("expected only the first request to reach the store, got %d gets and %d lock attempts", store.gets.Load(), store.acquires.Load())
+	}
+	if metrics := svc.Metrics(); metrics["cache_hits_total"] != 0 || metrics["follower_waits_total"] != 0 {
+		t.Fatalf("expected follower to share the stream without touching the cache, got %v", metrics)
+	}
+}
+
+func TestLeaderClientLeavingDoesNotAbortSharedStream(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(30_000),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	store := newMemoryStore()
+	svc := NewCachingService(cfg, router, store, fetcher)
+
+	leaderCtx, leave := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/big", nil)
+		leaderDone <- svc.Handle(leaderCtx, req, httptest.NewRecorder())
+	}()
+	if _, err := bodyWriter.Write([]byte("first ")); err != nil {
+		t.Fatalf("writing first chunk: %v", err)
+	}
+
+	followerRec := httptest.NewRecorder()
+	followerDone := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/big", nil)
+		followerDone <- svc.Handle(context.Background(), req, followerRec)
+	}()
+	waitFor(t, func() bool { return svc.Metrics()["requests_coalesced_total"] == 1 })
+
+	leave()
+	if fetcher.ctx.Err() != nil {
+		t.Fatal("expected the upstream request to outlive the leader's client")
+	}
+	if _, err := bodyWriter.Write([]byte("second")); err != nil {
+		t.Fatalf("writing second chunk: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	if err := <-followerDone; err != nil {
+		t.Fatalf("follower failed: %v", err)
+	}
+	if body := followerRec.Body.String(); body != "first second" {
+		t.Fatalf("expected the follower to get the whole body, got %q", body)
+	}
+	<-leaderDone
+	if metrics := svc.Metrics(); metrics["cache_sets_total"] != 1 {
+		t.Fatalf("expected the response to be cached, got %v", metrics)
+	}
+}
+
+func TestStalledUpstreamReleasesLeaderFlight(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(30_000),
+				LockTTL:       config.NewDuration(20 * time.Millisecond),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	svc := NewCachingService(cfg, router, newMemoryStore(), stalledFetcher{})
+
+	done := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/stuck", nil)
+		done <- svc.Handle(context.Background(), req, httptest.NewRecorder())
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errHeaderTimeout) {
+			t.Fatalf("expected the stalled fetch to time out, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the leader's fetch to be bounded by its lock TTL")
+	}
+	svc.flights.Range(func(key, _ any) bool {
+		t.Fatalf("expected the flight to be released, found %v", key)
+		return false
+	})
+}
+
+func TestSlowBodyOutlivesLeaderLockTTL(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(30_000),
+				LockTTL:       config.NewDuration(20 * time.Millisecond),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	svc := NewCachingService(cfg, router, newMemoryStore(), fetcher)
+
+	rec := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/downloads/big", nil)
+		done <- svc.Handle(context.Background(), req, rec)
+	}()
+	if _, err := bodyWriter.Write([]byte("first ")); err != nil {
+		t.Fatalf("writing first chunk: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if fetcher.ctx.Err() != nil {
+		t.Fatal("expected the body transfer not to be cut off by the lock TTL")
+	}
+	if _, err := bodyWriter.Write([]byte("second")); err != nil {
+		t.Fatalf("writing second chunk: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("leader failed: %v", err)
+	}
+	if body := rec.Body.String(); body != "first second" {
+		t.Fatalf("expected the whole body, got %q", body)
+	}
+}
+
+// stalledFetcher is an upstream that never responds.
+type stalledFetcher struct{}
+
+func (stalledFetcher) Fetch(ctx context.Context, _ string, _ *http.Request) (*proxy.Response, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stalledFetcher) Open(ctx context.Context, _ string, _ *http.Request) (*proxy.Stream, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFollowerDoesNotShareUncacheableStream(t *testing.T) {
+	svc := NewCachingService(config.Config{}, nil, newMemoryStore(), nil)
+	inFlight := newFlight()
+	inFlight.start(&proxy.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": []string{"private"}}}, nil, nil, false)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/account", nil)
+	shared, err := svc.followFlight(context.Background(), req, httptest.NewRecorder(), inFlight, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("following flight: %v", err)
+	}
+	if shared {
+		t.Fatal("expected private response not to be shared with other requests")
+	}
+}
+
+func TestFollowerStopsWaitingOnSlowLocalLeader(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior:   config.CacheBehaviorCache,
+				ExpireTimeout:   config.Milliseconds(30_000),
+				FollowerMaxWait: config.NewDuration(20 * time.Millisecond),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	fetcher := &slowLeaderFetcher{opened: make(chan struct{}), release: make(chan struct{})}
+	svc := NewCachingService(cfg, router, newMemoryStore(), fetcher)
+
+	leaderDone := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/slow", nil)
+		leaderDone <- svc.Handle(context.Background(), req, httptest.NewRecorder())
+	}()
+	<-fetcher.opened
+
+	followerRec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/slow", nil)
+	if err := svc.Handle(context.Background(), req, followerRec); err != nil {
+		t.Fatalf("follower failed: %v", err)
+	}
+	if body := followerRec.Body.String(); body != "own" {
+		t.Fatalf("expected the follower to fetch the page itself, got %q", body)
+	}
+	if metrics := svc.Metrics(); metrics["follower_timeouts_total"] != 1 || metrics["requests_coalesced_total"] != 0 {
+		t.Fatalf("expected one follower timeout and no coalesced request, got %v", metrics)
+	}
+
+	close(fetcher.release)
+	if err := <-leaderDone; err != nil {
+		t.Fatalf("leader failed: %v", err)
+	}
+}
+
+func TestFollowersShareLocalLeaderFailure(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(30_000),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	fetcher := &slowLeaderFetcher{opened: make(chan struct{}), release: make(chan struct{}), err: errors.New("upstream down")}
+	svc := NewCachingService(cfg, router, newMemoryStore(), fetcher)
+
+	handle := func() <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/down", nil)
+			done <- svc.Handle(context.Background(), req, httptest.NewRecorder())
+		}()
+		return done
+	}
+
+	leaderDone := handle()
+	<-fetcher.opened
+	const followers = 5
+	var dones []<-chan error
+	for i := 0; i < followers; i++ {
+		dones = append(dones, handle())
+	}
+	// Give the followers time to attach to the leader's flight.
+	time.Sleep(50 * time.Millisecond)
+	close(fetcher.release)
+
+	if err := <-leaderDone; err == nil {
+		t.Fatal("expected the leader to fail")
+	}
+	for i, done := range dones {
+		if err := <-done; !errors.Is(err, errLeaderFailed) {
+			t.Fatalf("expected follower %d to share the leader's failure, got %v", i, err)
+		}
+	}
+	if fetches := fetcher.fetches.Load(); fetches != 1 {
+		t.Fatalf("expected a single upstream request, got %d", fetches)
+	}
+}
+
+// slowLeaderFetcher holds the leader's upstream response, or err, until release
+// is closed, while buffered fetches answer right away.
+type slowLeaderFetcher struct {
+	opened  chan struct{}
+	release chan struct{}
+	err     error
+	fetches atomic.Uint64
+}
+
+func (f *slowLeaderFetcher) Fetch(context.Context, string, *http.Request) (*proxy.Response, error) {
+	f.fetches.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &proxy.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte("own")}, nil
+}
+
+func (f *slowLeaderFetcher) Open(context.Context, string, *http.Request) (*proxy.Stream, error) {
+	if f.fetches.Add(1) == 1 {
+		close(f.opened)
+	}
+	<-f.release
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("leader"))}, nil
+}
+
+type storeCallCounter struct {
+	cache.Store
+	gets     atomic.Uint64
+	acquires atomic.Uint64
+}
+
+func (s *storeCallCounter) Get(ctx context.Context, key string) (*cache.Entry, error) {
+	s.gets.Add(1)
+	return s.Store.Get(ctx, key)
+}
+
+func (s *storeCallCounter) TryAcquireLeader(ctx context.Context, key string, ttl time.Duration) (*cache.Lock, bool, error) {
+	s.acquires.Add(1)
+	return s.Store.TryAcquireLeader(ctx, key, ttl)
+}
+
+type streamingFetcher struct {
+	count  atomic.Uint64
+	stream *proxy.Stream
+	ctx    context.Context
+}
+
+func (f *streamingFetcher) Fetch(context.Context, string, *http.Request) (*proxy.Response, error) {
+	return nil, errors.New("unexpected buffered fetch")
+}
+
+func (f *streamingFetcher) Open(ctx context.Context, _ string, _ *http.Request) (*proxy.Stream, error) {
+	f.count.Add(1)
+	f.ctx = ctx
+	return f.stream, nil
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesceSharesUpstreamFetchWithoutStore(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCoalesce},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	svc := NewCachingService(cfg, router, nil, fetcher)
+
+	const concurrency = 4
+	recorders := make([]*httptest.ResponseRecorder, concurrency)
+	errCh := make(chan error, concurrency)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		go func(rec *httptest.ResponseRecorder) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/live/score", nil)
+			errCh <- svc.Handle(context.Background(), req, rec)
+		}(recorders[i])
+	}
+	waitFor(t, func() bool { return svc.Metrics()["requests_coalesced_total"] == concurrency-1 })
+
+	if _, err := bodyWriter.Write([]byte("3-1")); err != nil {
+		t.Fatalf("writing body: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	for i := 0; i < concurrency; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	for _, rec := range recorders {
+		if rec.Body.String() != "3-1" {
+			t.Fatalf("unexpected body %q", rec.Body.String())
+		}
+	}
+	if fetcher.count.Load() != 1 {
+		t.Fatalf("expected one shared upstream fetch, got %d", fetcher.count.Load())
+	}
+}
+
+func TestCoalesceSlowBodyOutlivesLockTTL(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCoalesce,
+				LockTTL:       config.NewDuration(20 * time.Millisecond),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	svc := NewCachingService(cfg, router, nil, fetcher)
+
+	rec := httptest.NewRecorder()
+	done := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/live/feed", nil)
+		done <- svc.Handle(context.Background(), req, rec)
+	}()
+	if _, err := bodyWriter.Write([]byte("first ")); err != nil {
+		t.Fatalf("writing first chunk: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if fetcher.ctx.Err() != nil {
+		t.Fatal("expected the body transfer not to be cut off by the lock TTL")
+	}
+	if _, err := bodyWriter.Write([]byte("second")); err != nil {
+		t.Fatalf("writing second chunk: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if body := rec.Body.String(); body != "first second" {
+		t.Fatalf("expected the whole body, got %q", body)
+	}
+}
+
+func TestCoalesceDoesNotShareAcrossCredentials(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCoalesce},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	fetcher := &countingFetcher{
+		delay: 20 * time.Millisecond,
+		responseFn: func(request *http.Request) *proxy.Response {
+			body := "account of " + request.Header.Get("Authorization") + request.Header.Get("Cookie")
+			return &proxy.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte(body)}
+		},
+	}
+	svc := NewCachingService(cfg, router, nil, fetcher)
+
+	credentials := []struct{ header, value string }{
+		{"Authorization", "Bearer alice"},
+		{"Authorization", "Bearer bob"},
+		{"Cookie", "session=carol"},
+		{"Cookie", "session=dave"},
+	}
+	var wg sync.WaitGroup
+	for _, credential := range credentials {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/account", nil)
+			req.Header.Set(credential.header, credential.value)
+			rec := httptest.NewRecorder()
+			if err := svc.Handle(context.Background(), req, rec); err != nil {
+				t.Errorf("handle error: %v", err)
+				return
+			}
+			if body, want := rec.Body.String(), "account of "+credential.value; body != want {
+				t.Errorf("expected %q, got %q", want, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetcher.count.Load() != uint64(len(credentials)) {
+		t.Fatalf("expected every user's request to reach upstream, got %d fetches", fetcher.count.Load())
+	}
+}
+
+func TestCoalesceClientLeavingDoesNotAbortSharedStream(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCoalesce},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	fetcher := &streamingFetcher{stream: &proxy.Stream{StatusCode: http.StatusOK, Header: http.Header{}, Body: bodyReader}}
+	svc := NewCachingService(cfg, router, nil, fetcher)
+
+	leaderCtx, leave := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/live/score", nil)
+		leaderDone <- svc.Handle(leaderCtx, req, httptest.NewRecorder())
+	}()
+	if _, err := bodyWriter.Write([]byte("first ")); err != nil {
+		t.Fatalf("writing first chunk: %v", err)
+	}
+
+	followerRec := httptest.NewRecorder()
+	followerDone := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/live/score", nil)
+		followerDone <- svc.Handle(context.Background(), req, followerRec)
+	}()
+	waitFor(t, func() bool { return svc.Metrics()["requests_coalesced_total"] == 1 })
+
+	leave()
+	if fetcher.ctx.Err() != nil {
+		t.Fatal("expected the upstream request to outlive the first client")
+	}
+	if _, err := bodyWriter.Write([]byte("second")); err != nil {
+		t.Fatalf("writing second chunk: %v", err)
+	}
+	_ = bodyWriter.Close()
+
+	if err := <-followerDone; err != nil {
+		t.Fatalf("follower failed: %v", err)
+	}
+	if body := followerRec.Body.String(); body != "first second" {
+		t.Fatalf("expected the follower to get the whole body, got %q", body)
+	}
+	<-leaderDone
+}
+
+func TestCoalesceDoesNotShareUnshareableResponses(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCoalesce},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	for _, header := range []http.Header{
+		{"Cache-Control": []string{"private"}},
+		{"Cache-Control": []string{"no-store"}},
+		{"Cache-Control": []string{"no-cache"}},
+		{"Set-Cookie": []string{"session=mine"}},
+	} {
+		fetcher := &countingFetcher{
+			response: &proxy.Response{StatusCode: http.StatusOK, Header: header, Body: []byte("mine")},
+			delay:    10 * time.Millisecond,
+		}
+		svc := NewCachingService(cfg, router, nil, fetcher)
+
+		const concurrency = 5
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/account", nil)
+				if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+					t.Errorf("handle error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if fetcher.count.Load() != concurrency {
+			t.Fatalf("expected every request to reach upstream for %v, got %d fetches", header, fetcher.count.Load())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -9,20 +10,21 @@ import (
 
 	"github.com/robertomachorro/doormanlb/internal/cache"
 	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/keybuilder"
 	"github.com/robertomachorro/doormanlb/internal/proxy"
 	"github.com/robertomachorro/doormanlb/internal/routing"
 )
 
 func TestHandlePassthroughBypassesCache(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -60,17 +62,17 @@ func TestHandlePassthroughBypassesCache(t *testing.T) {
 
 func TestHandleCacheHitSkipsUpstream(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 60000,
+				ExpireTimeout: config.Milliseconds(60000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -108,17 +110,17 @@ func TestHandleCacheHitSkipsUpstream(t *testing.T) {
 
 func TestHandleCacheMissFetchesAndStores(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 1200,
+				ExpireTimeout: config.Milliseconds(1200),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -165,17 +167,17 @@ func TestHandleCacheMissFetchesAndStores(t *testing.T) {
 
 func TestHandleCacheMissDoesNotStore5xx(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 5000,
+				ExpireTimeout: config.Milliseconds(5000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -205,19 +207,391 @@ func TestHandleCacheMissDoesNotStore5xx(t *testing.T) {
 	}
 }
 
+func TestHandleCacheMissHonorsUpstreamCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttlMode      string
+		cacheControl string
+		wantSet      bool
+		wantTTL      time.Duration
+	}{
+		{name: "fixed ignores headers", ttlMode: config.TTLModeFixed, cacheControl: "no-store", wantSet: true, wantTTL: 60 * time.Second},
+		{name: "upstream max-age", ttlMode: config.TTLModeUpstream, cacheControl: "max-age=5", wantSet: true, wantTTL: 5 * time.Second},
+		{name: "upstream above default", ttlMode: config.TTLModeUpstream, cacheControl: "s-maxage=600", wantSet: true, wantTTL: 600 * time.Second},
+		{name: "capped by expireTimeout", ttlMode: config.TTLModeUpstreamCapped, cacheControl: "s-maxage=600", wantSet: true, wantTTL: 60 * time.Second},
+		{name: "default when no directive", ttlMode: config.TTLModeUpstream, cacheControl: "public", wantSet: true, wantTTL: 60 * time.Second},
+		{name: "no-store skips cache", ttlMode: config.TTLModeUpstream, cacheControl: "no-store"},
+		{name: "private skips cache", ttlMode: config.TTLModeUpstreamCapped, cacheControl: "private, max-age=30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				Services: []config.Service{{URL: "http://svc-a"}},
+				Strategy: config.StrategyRoundRobin,
+				Endpoints: map[string]config.EndpointConfig{
+					config.DefaultEndpointKey: {
+						CacheBehavior: config.CacheBehaviorCache,
+						ExpireTimeout: config.Milliseconds(60_000),
+						TTLMode:       tt.ttlMode,
+					},
+				},
+			}
+
+			router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+			if err != nil {
+				t.Fatalf("creating router: %v", err)
+			}
+
+			store := &fakeStore{}
+			fetcher := &fakeFetcher{
+				response: &proxy.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Cache-Control": []string{tt.cacheControl}},
+					Body:       []byte("fresh"),
+				},
+			}
+
+			svc := NewCachingService(cfg, router, store, fetcher)
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+			recorder := httptest.NewRecorder()
+
+			if err := svc.Handle(context.Background(), req, recorder); err != nil {
+				t.Fatalf("handling request: %v", err)
+			}
+
+			if recorder.Body.String() != "fresh" {
+				t.Fatalf("expected upstream body to be served, got %q", recorder.Body.String())
+			}
+			if !tt.wantSet {
+				if store.setCalled != 0 {
+					t.Fatalf("expected no cache set, got %d", store.setCalled)
+				}
+				if svc.Metrics()["cache_skips_no_store_total"] != 1 {
+					t.Fatalf("expected cache_skips_no_store_total=1, got %d", svc.Metrics()["cache_skips_no_store_total"])
+				}
+				return
+			}
+			if store.setCalled != 1 {
+				t.Fatalf("expected one cache set, got %d", store.setCalled)
+			}
+			if store.lastTTL != tt.wantTTL {
+				t.Fatalf("expected ttl %s, got %s", tt.wantTTL, store.lastTTL)
+			}
+		})
+	}
+}
+
+func TestHandleCacheServesStaleWhileRevalidating(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior:        config.CacheBehaviorCache,
+				ExpireTimeout:        config.Milliseconds(60_000),
+				StaleWhileRevalidate: config.Milliseconds(60_000),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	ctx := context.Background()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/articles?a=1", nil)
+	cacheKey := keybuilder.Build(req, keybuilder.Options{})
+
+	store := cache.NewMemoryStore(0, 0)
+	stale := &cache.Entry{
+		Response:   &proxy.Response{StatusCode: http.StatusOK, Body: []byte("stale")},
+		FreshUntil: time.Now().Add(-time.Second),
+	}
+	if err := store.Set(ctx, cacheKey, stale, time.Minute); err != nil {
+		t.Fatalf("seeding stale entry: %v", err)
+	}
+
+	fetcher := &countingFetcher{
+		response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("fresh")},
+		delay:    20 * time.Millisecond,
+	}
+	svc := NewCachingService(cfg, router, store, fetcher)
+	recorder := httptest.NewRecorder()
+
+	if err := svc.Handle(ctx, req, recorder); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if recorder.Body.String() != "stale" {
+		t.Fatalf("expected stale body to be served immediately, got %q", recorder.Body.String())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entry, err := store.Get(ctx, cacheKey)
+		if err != nil {
+			t.Fatalf("reading cache: %v", err)
+		}
+		if entry != nil && entry.IsFresh(time.Now()) && string(entry.Response.Body) == "fresh" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected background refresh to store a fresh entry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if fetcher.count.Load() != 1 {
+		t.Fatalf("expected one background upstream fetch, got %d", fetcher.count.Load())
+	}
+	metrics := svc.Metrics()
+	if metrics["stale_hits_total"] != 1 || metrics["background_refreshes_total"] != 1 {
+		t.Fatalf("unexpected stale metrics %v", metrics)
+	}
+}
+
+func TestHandleCacheTreatsEntryPastStaleWindowAsMiss(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior:        config.CacheBehaviorCache,
+				ExpireTimeout:        config.Milliseconds(60_000),
+				StaleWhileRevalidate: config.Milliseconds(1_000),
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	ctx := context.Background()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/articles?a=1", nil)
+	cacheKey := keybuilder.Build(req, keybuilder.Options{})
+
+	store := cache.NewMemoryStore(0, 0)
+	stale := &cache.Entry{
+		Response:   &proxy.Response{StatusCode: http.StatusOK, Body: []byte("stale")},
+		FreshUntil: time.Now().Add(-time.Minute),
+	}
+	if err := store.Set(ctx, cacheKey, stale, time.Hour); err != nil {
+		t.Fatalf("seeding stale entry: %v", err)
+	}
+
+	fetcher := &countingFetcher{response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("fresh")}}
+	svc := NewCachingService(cfg, router, store, fetcher)
+	recorder := httptest.NewRecorder()
+
+	if err := svc.Handle(ctx, req, recorder); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if recorder.Body.String() != "fresh" {
+		t.Fatalf("expected synchronous refetch past the stale window, got %q", recorder.Body.String())
+	}
+	if svc.Metrics()["stale_hits_total"] != 0 {
+		t.Fatal("expected no stale hit past the stale window")
+	}
+}
+
+func TestHandleCacheServesStaleIfUpstreamFails(t *testing.T) {
+	tests := []struct {
+		name         string
+		staleIfError int64
+		response     *proxy.Response
+		fetchErr     error
+		wantErr      bool
+		wantBody     string
+	}{
+		{name: "connection error", staleIfError: 60_000, fetchErr: errors.New("connection refused"), wantBody: "stale"},
+		{name: "5xx response", staleIfError: 60_000, response: &proxy.Response{StatusCode: http.StatusServiceUnavailable, Body: []byte("down")}, wantBody: "stale"},
+		{name: "healthy upstream replaces entry", staleIfError: 60_000, response: &proxy.Response{StatusCode: http.StatusOK, Body: []byte("fresh")}, wantBody: "fresh"},
+		{name: "no window returns error", fetchErr: errors.New("connection refused"), wantErr: true},
+		{name: "no window passes 5xx through", response: &proxy.Response{StatusCode: http.StatusServiceUnavailable, Body: []byte("down")}, wantBody: "down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				Services: []config.Service{{URL: "http://svc-a"}},
+				Strategy: config.StrategyRoundRobin,
+				Endpoints: map[string]config.EndpointConfig{
+					config.DefaultEndpointKey: {
+						CacheBehavior: config.CacheBehaviorCache,
+						ExpireTimeout: config.Milliseconds(60_000),
+						StaleIfError:  config.Milliseconds(tt.staleIfError),
+					},
+				},
+			}
+
+			router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+			if err != nil {
+				t.Fatalf("creating router: %v", err)
+			}
+
+			ctx := context.Background()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+			store := cache.NewMemoryStore(0, 0)
+			stale := &cache.Entry{
+				Response:   &proxy.Response{StatusCode: http.StatusOK, Body: []byte("stale")},
+				FreshUntil: time.Now().Add(-time.Second),
+			}
+			if err := store.Set(ctx, keybuilder.Build(req, keybuilder.Options{}), stale, time.Minute); err != nil {
+				t.Fatalf("seeding stale entry: %v", err)
+			}
+
+			svc := NewCachingService(cfg, router, store, &fakeFetcher{response: tt.response, err: tt.fetchErr})
+			recorder := httptest.NewRecorder()
+
+			err = svc.Handle(ctx, req, recorder)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected upstream error without a stale-if-error window")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handling request: %v", err)
+			}
+			if recorder.Body.String() != tt.wantBody {
+				t.Fatalf("expected body %q, got %q", tt.wantBody, recorder.Body.String())
+			}
+
+			servedStale := tt.wantBody == "stale"
+			if got := recorder.Header().Get("X-Cache") == "STALE"; got != servedStale {
+				t.Fatalf("expected X-Cache STALE=%v, got header %q", servedStale, recorder.Header().Get("X-Cache"))
+			}
+			if servedStale && recorder.Header().Get("Warning") == "" {
+				t.Fatal("expected Warning header on stale response")
+			}
+			wantHits := uint64(0)
+			if servedStale {
+				wantHits = 1
+			}
+			if got := svc.Metrics()["stale_if_error_hits_total"]; got != wantHits {
+				t.Fatalf("expected stale_if_error_hits_total=%d, got %d", wantHits, got)
+			}
+		})
+	}
+}
+
+func TestHandleCacheStoresVariantsPerVaryHeader(t *testing.T) {
+	honorVary := true
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(60_000),
+				HonorVary:     &honorVary,
+				VaryHeaders:   []string{"Accept-Language"},
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	fetcher := &countingFetcher{
+		responseFn: func(request *http.Request) *proxy.Response {
+			return &proxy.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Vary": []string{"Accept-Language"}},
+				Body:       []byte("hello " + request.Header.Get("Accept-Language")),
+			}
+		},
+	}
+	svc := NewCachingService(cfg, router, cache.NewMemoryStore(0, 0), fetcher)
+
+	requests := []struct {
+		language string
+		wantBody string
+	}{
+		{language: "en", wantBody: "hello en"},
+		{language: "fr", wantBody: "hello fr"},
+		{language: "en", wantBody: "hello en"},
+		{language: "fr", wantBody: "hello fr"},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/articles", nil)
+		req.Header.Set("Accept-Language", tt.language)
+		recorder := httptest.NewRecorder()
+
+		if err := svc.Handle(context.Background(), req, recorder); err != nil {
+			t.Fatalf("handling request: %v", err)
+		}
+		if recorder.Body.String() != tt.wantBody {
+			t.Fatalf("expected body %q for %s, got %q", tt.wantBody, tt.language, recorder.Body.String())
+		}
+	}
+
+	if fetcher.count.Load() != 2 {
+		t.Fatalf("expected one upstream fetch per variant, got %d", fetcher.count.Load())
+	}
+	if got := svc.Metrics()["cache_hits_total"]; got != 2 {
+		t.Fatalf("expected repeated variants to hit cache, got %d hits", got)
+	}
+}
+
+func TestHandleCacheSkipsVaryOutsideAllowlist(t *testing.T) {
+	honorVary := true
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(60_000),
+				HonorVary:     &honorVary,
+				VaryHeaders:   []string{"Accept-Language"},
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	store := &fakeStore{}
+	fetcher := &fakeFetcher{response: &proxy.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Vary": []string{"Cookie"}},
+		Body:       []byte("personal"),
+	}}
+	svc := NewCachingService(cfg, router, store, fetcher)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/profile", nil)
+	if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if store.setCalled != 0 {
+		t.Fatalf("expected response varying on Cookie not to be cached, got %d sets", store.setCalled)
+	}
+	if svc.Metrics()["cache_skips_vary_total"] != 1 {
+		t.Fatal("expected cache_skips_vary_total to be incremented")
+	}
+}
+
 func TestHandleCacheMissFollowerWaitsAndUsesCache(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 5000,
+				ExpireTimeout: config.Milliseconds(5000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -252,17 +626,17 @@ func TestHandleCacheMissFollowerWaitsAndUsesCache(t *testing.T) {
 
 func TestHandleCacheMissFollowerTimeoutFallsBackToFetch(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 5000,
+				ExpireTimeout: config.Milliseconds(5000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -296,19 +670,82 @@ func TestHandleCacheMissFollowerTimeoutFallsBackToFetch(t *testing.T) {
 	}
 }
 
+func TestHandleCacheUsesEndpointLeaderSettings(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {
+				CacheBehavior: config.CacheBehaviorCache,
+				ExpireTimeout: config.Milliseconds(5000),
+			},
+			"/reports/*": {
+				LockTTL:             config.NewDuration(90 * time.Second),
+				CacheAttempts:       2,
+				CacheAttemptBackoff: config.Milliseconds(0),
+			},
+			"/search": {
+				FollowerMaxWait: config.NewDuration(2 * time.Second),
+				CacheAttempts:   5,
+			},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	store := &fakeStore{forceFollower: true, waitErr: cache.ErrWaitTimeout}
+	svc := NewCachingService(cfg, router, store, &fakeFetcher{})
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/reports/monthly", nil)
+	if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if store.lastLockTTL != 90*time.Second {
+		t.Fatalf("expected the endpoint's lock TTL, got %s", store.lastLockTTL)
+	}
+	if len(store.waitTimeouts) != 2 || store.waitTimeouts[0] != 90*time.Second {
+		t.Fatalf("expected two waits of the lock TTL, got %v", store.waitTimeouts)
+	}
+
+	// Followers of /search never wait on a leader for more than two seconds.
+	store.waitTimeouts = nil
+	store.waitErr = nil
+	store.getResponses = nil
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/search?q=go", nil)
+	if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if len(store.waitTimeouts) == 0 || store.waitTimeouts[0] > 2*time.Second {
+		t.Fatalf("expected waits bounded by followerMaxWait, got %v", store.waitTimeouts)
+	}
+
+	metrics := svc.Metrics()
+	if got := metrics[`endpoint_follower_timeouts_total{endpoint="/reports/*"}`]; got != 2 {
+		t.Fatalf("expected 2 follower timeouts for /reports/*, got %d", got)
+	}
+	if got := metrics[`endpoint_fallback_fetches_total{endpoint="/reports/*"}`]; got != 1 {
+		t.Fatalf("expected 1 fallback fetch for /reports/*, got %d", got)
+	}
+	if got := metrics[`endpoint_follower_waits_total{endpoint="/search"}`]; got != uint64(len(store.waitTimeouts)) {
+		t.Fatalf("expected %d follower waits for /search, got %d", len(store.waitTimeouts), got)
+	}
+}
+
 func TestReadyFailsWhenCacheConfiguredButMissingStore(t *testing.T) {
 	cfg := config.Config{
-		Services: []string{"http://svc-a"},
+		Services: []config.Service{{URL: "http://svc-a"}},
 		Strategy: config.StrategyRoundRobin,
 		Endpoints: map[string]config.EndpointConfig{
 			config.DefaultEndpointKey: {
 				CacheBehavior: config.CacheBehaviorCache,
-				ExpireTimeout: 5000,
+				ExpireTimeout: config.Milliseconds(5000),
 			},
 		},
 	}
 
-	router, err := routing.NewRouter(cfg.Services, cfg.Strategy)
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
 	if err != nil {
 		t.Fatalf("creating router: %v", err)
 	}
@@ -319,12 +756,114 @@ func TestReadyFailsWhenCacheConfiguredButMissingStore(t *testing.T) {
 	}
 }
 
+func TestReadyReportsUnhealthyUpstreamsWithoutFailing(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	router, err := routing.NewRouter([]string{down.URL}, config.StrategyRoundRobin)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.StartHealthChecks(map[string]routing.HealthCheck{
+		down.URL: {Path: "/", Interval: 5 * time.Millisecond, Timeout: time.Second, HealthyThreshold: 1, UnhealthyThreshold: 1, ExpectedStatus: http.StatusOK},
+	})
+	defer router.Close()
+
+	svc := NewCachingService(config.Config{}, router, nil, &fakeFetcher{})
+	deadline := time.Now().Add(2 * time.Second)
+	for svc.UpstreamHealth()[down.URL] {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the upstream to be marked unhealthy")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Replicas stay ready during an upstream outage, so they can serve stale content.
+	if err := svc.Ready(context.Background()); err != nil {
+		t.Fatalf("expected readiness not to depend on upstream health, got %v", err)
+	}
+	if got, ok := svc.Metrics()[`upstream_healthy{service="`+down.URL+`"}`]; !ok || got != 0 {
+		t.Fatalf("expected upstream_healthy metric of 0, got %d (present=%v)", got, ok)
+	}
+}
+
+func TestUpstreamErrorsEjectService(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}, {URL: "http://svc-b"}},
+		Strategy: config.StrategyLeastConnections,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	router.SetOutlierDetection(routing.OutlierDetection{ConsecutiveFailures: 2, BaseEjectionTime: time.Minute, MaxEjectionPercent: 50})
+
+	fetcher := &fakeFetcher{response: &proxy.Response{StatusCode: http.StatusBadGateway}}
+	svc := NewCachingService(cfg, router, &fakeStore{}, fetcher)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+		if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling request: %v", err)
+		}
+	}
+
+	metrics := svc.Metrics()
+	if got := metrics[`upstream_ejected{service="http://svc-a"}`]; got != 1 {
+		t.Fatalf("expected svc-a to be ejected after two 502s, got %d", got)
+	}
+	if got := metrics[`upstream_ejections_total{service="http://svc-a"}`]; got != 1 {
+		t.Fatalf("expected one ejection of svc-a, got %d", got)
+	}
+}
+
+func TestMetricsIncludeStoreCounters(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+		},
+	}
+
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	store := &reportingStore{fakeStore: &fakeStore{}, metrics: map[string]uint64{"cache_l1_hits_total": 7}}
+	svc := NewCachingService(cfg, router, store, &fakeFetcher{})
+
+	metrics := svc.Metrics()
+	if metrics["cache_l1_hits_total"] != 7 {
+		t.Fatalf("expected cache_l1_hits_total=7, got %d", metrics["cache_l1_hits_total"])
+	}
+	if _, ok := metrics["requests_total"]; !ok {
+		t.Fatal("expected service counters to remain present")
+	}
+}
+
+type reportingStore struct {
+	*fakeStore
+	metrics map[string]uint64
+}
+
+func (r *reportingStore) Metrics() map[string]uint64 {
+	return r.metrics
+}
+
 type fakeStore struct {
 	getCalled     int
 	acquireCalled int
 	releaseCalled int
 	publishCalled int
 	waitCalled    int
+	waitTimeouts  []time.Duration
 	setCalled     int
 	getResponse   *proxy.Response
 	getResponses  []*proxy.Response
@@ -335,26 +874,31 @@ type fakeStore struct {
 	waitErr       error
 	lastKey       string
 	lastResponse  *proxy.Response
+	lastEntry     *cache.Entry
 	lastTTL       time.Duration
 	lastLockTTL   time.Duration
 	lastLock      *cache.Lock
 }
 
-func (f *fakeStore) Get(_ context.Context, key string) (*proxy.Response, error) {
+func (f *fakeStore) Get(_ context.Context, key string) (*cache.Entry, error) {
 	f.getCalled++
 	f.lastKey = key
+	response := f.getResponse
 	if len(f.getResponses) > 0 {
-		response := f.getResponses[0]
+		response = f.getResponses[0]
 		f.getResponses = f.getResponses[1:]
-		return response, f.getErr
 	}
-	return f.getResponse, f.getErr
+	if response == nil {
+		return nil, f.getErr
+	}
+	return &cache.Entry{Response: response}, f.getErr
 }
 
-func (f *fakeStore) Set(_ context.Context, key string, response *proxy.Response, ttl time.Duration) error {
+func (f *fakeStore) Set(_ context.Context, key string, entry *cache.Entry, ttl time.Duration) error {
 	f.setCalled++
 	f.lastKey = key
-	f.lastResponse = response
+	f.lastResponse = entry.Response
+	f.lastEntry = entry
 	f.lastTTL = ttl
 	return f.setErr
 }
@@ -387,8 +931,9 @@ func (f *fakeStore) PublishDone(_ context.Context, _ string) error {
 	return nil
 }
 
-func (f *fakeStore) WaitForDone(_ context.Context, _ string, _ time.Duration) error {
+func (f *fakeStore) WaitForDone(_ context.Context, _ string, timeout time.Duration) error {
 	f.waitCalled++
+	f.waitTimeouts = append(f.waitTimeouts, timeout)
 	if f.waitErr != nil {
 		return f.waitErr
 	}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/routing"
+)
+
+// retryBudget tracks upstream requests in flight and how many of them are
+// retrying, so retries cannot multiply load on an already failing pool.
+type retryBudget struct {
+	active   atomic.Int64
+	retrying atomic.Int64
+}
+
+// upstreamTry is one attempt at an upstream request, holding its router lease
+// and per-try timeout until end is called.
+type upstreamTry struct {
+	ctx    context.Context
+	lease  *routing.Lease
+	cancel context.CancelFunc
+}
+
+func (t *upstreamTry) end() {
+	t.cancel()
+	t.lease.Release()
+}
+
+// upstreamRequest walks the attempts of one upstream request.
+type upstreamRequest struct {
+	service  *CachingService
+	router   *routing.Router
+	policy   config.RetryConfig
+	ctx      context.Context
+	key      string
+	tried    []string
+	retrying bool
+}
+
+func (s *CachingService) beginUpstream(ctx context.Context, state *serviceState, request *http.Request) *upstreamRequest {
+	s.stats.upstreamFetches.Add(1)
+	s.retryBudget.active.Add(1)
+	endpoint := state.endpointFor(request)
+	return &upstreamRequest{
+		service: s,
+		router:  state.routerFor(endpoint),
+		policy:  state.config.Retry,
+		ctx:     ctx,
+		key:     state.routingKey(request, endpoint),
+	}
+}
+
+// routingKey identifies the request for CONSISTENT_HASH routing: its cache key,
+// so every fetch of a page lands on the backend that already rendered it.
+func (st *serviceState) routingKey(request *http.Request, endpoint config.EndpointConfig) string {
+	if st.config.Pool(endpoint.Pool).Strategy != config.StrategyConsistentHash {
+		return ""
+	}
+	return st.cacheKey(request, endpoint)
+}
+
+func (u *upstreamRequest) done() {
+	u.service.retryBudget.active.Add(-1)
+	if u.retrying {
+		u.service.retryBudget.retrying.Add(-1)
+	}
+}
+
+// next starts the next attempt on a service not tried yet.
+func (u *upstreamRequest) next() *upstreamTry {
+	lease := u.router.AcquireFor(u.key, u.tried)
+	if lease == nil {
+		lease = u.router.AcquireFor(u.key, nil)
+	}
+	u.tried = append(u.tried, lease.URL)
+
+	ctx, cancel := u.ctx, context.CancelFunc(func() {})
+	if timeout := u.policy.PerTryTimeoutDuration(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(u.ctx, timeout)
+	}
+	return &upstreamTry{ctx: ctx, lease: lease, cancel: cancel}
+}
+
+// shouldRetry reports whether an attempt that ended with statusCode or err is
+// retried, counting the retry if so.
+func (u *upstreamRequest) shouldRetry(statusCode int, err error) bool {
+	policy := u.policy
+	if len(u.tried) >= policy.Attempts() || u.ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) || !policy.RetriesConnectErrors() {
+			return false
+		}
+	} else if !policy.RetriesStatus(statusCode) {
+		return false
+	}
+	if !u.router.CanAvoid(u.tried) {
+		return false
+	}
+	if !u.withinBudget() {
+		u.service.stats.retryBudgetSkips.Add(1)
+		return false
+	}
+
+	u.service.stats.upstreamRetries.Add(1)
+	return true
+}
+
+func (u *upstreamRequest) withinBudget() bool {
+	if u.retrying {
+		return true
+	}
+	budget := &u.service.retryBudget
+	policy := u.policy
+	allowed := max(int64(policy.MinConcurrency()), budget.active.Load()*int64(policy.Budget())/100)
+	if budget.retrying.Add(1) > allowed {
+		budget.retrying.Add(-1)
+		return false
+	}
+	u.retrying = true
+	return true
+}
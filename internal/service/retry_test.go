@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+	"github.com/robertomachorro/doormanlb/internal/routing"
+)
+
+// serviceFetcher answers per upstream service URL and records the order tried.
+type serviceFetcher struct {
+	mu        sync.Mutex
+	tried     []string
+	responses map[string]*proxy.Response
+}
+
+func (f *serviceFetcher) Fetch(_ context.Context, upstreamBaseURL string, _ *http.Request) (*proxy.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tried = append(f.tried, upstreamBaseURL)
+	if response, ok := f.responses[upstreamBaseURL]; ok {
+		return response, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func newRetryService(t *testing.T, retry config.RetryConfig, fetcher responseFetcher) *CachingService {
+	t.Helper()
+	return newThreeServiceService(t, config.StrategyRoundRobin, retry, fetcher)
+}
+
+func newThreeServiceService(t *testing.T, strategy string, retry config.RetryConfig, fetcher responseFetcher) *CachingService {
+	t.Helper()
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://svc-a"}, {URL: "http://svc-b"}, {URL: "http://svc-c"}},
+		Strategy: strategy,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+		},
+		Retry: retry,
+	}
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	return NewCachingService(cfg, router, &fakeStore{}, fetcher)
+}
+
+func TestRetryUsesADifferentServicePerAttempt(t *testing.T) {
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://svc-b": {StatusCode: http.StatusServiceUnavailable},
+		"http://svc-c": {StatusCode: http.StatusServiceUnavailable},
+	}}
+	svc := newRetryService(t, config.RetryConfig{MaxAttempts: 3}, fetcher)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+	if err := svc.Handle(context.Background(), req, recorder); err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last try's 503, got %d", recorder.Code)
+	}
+	seen := map[string]bool{}
+	for _, serviceURL := range fetcher.tried {
+		seen[serviceURL] = true
+	}
+	if len(fetcher.tried) != 3 || len(seen) != 3 {
+		t.Fatalf("expected one try per service, got %v", fetcher.tried)
+	}
+	if got := svc.Metrics()["upstream_retries_total"]; got != 2 {
+		t.Fatalf("expected two retries, got %d", got)
+	}
+}
+
+func TestRetryReturnsFirstSuccess(t *testing.T) {
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://svc-b": {StatusCode: http.StatusOK, Body: []byte("ok")},
+		"http://svc-c": {StatusCode: http.StatusOK, Body: []byte("ok")},
+	}}
+	svc := newRetryService(t, config.RetryConfig{MaxAttempts: 3}, fetcher)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+	if err := svc.Handle(context.Background(), req, recorder); err != nil {
+		t.Fatalf("handling request after a retry: %v", err)
+	}
+	if recorder.Code != http.StatusOK || len(fetcher.tried) != 2 {
+		t.Fatalf("expected success on the second try, got %d after %v", recorder.Code, fetcher.tried)
+	}
+}
+
+func TestRetryStopsAtMaxAttemptsAndUnlistedStatus(t *testing.T) {
+	tests := map[string]struct {
+		retry     config.RetryConfig
+		responses map[string]*proxy.Response
+		tries     int
+	}{
+		"disabled": {
+			retry: config.RetryConfig{},
+			tries: 1,
+		},
+		"max attempts": {
+			retry: config.RetryConfig{MaxAttempts: 2},
+			tries: 2,
+		},
+		"status not retried": {
+			retry:     config.RetryConfig{MaxAttempts: 3},
+			responses: map[string]*proxy.Response{"http://svc-a": {StatusCode: http.StatusInternalServerError}},
+			tries:     1,
+		},
+		"connect errors not retried": {
+			retry: config.RetryConfig{MaxAttempts: 3, RetryOnConnectError: new(bool)},
+			tries: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			fetcher := &serviceFetcher{responses: tc.responses}
+			svc := newRetryService(t, tc.retry, fetcher)
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+			_ = svc.Handle(context.Background(), req, httptest.NewRecorder())
+
+			if len(fetcher.tried) != tc.tries {
+				t.Fatalf("expected %d tries, got %v", tc.tries, fetcher.tried)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetLimitsRetries(t *testing.T) {
+	zero := 0
+	fetcher := &serviceFetcher{}
+	svc := newRetryService(t, config.RetryConfig{MaxAttempts: 3, BudgetPercent: &zero, MinRetryConcurrency: &zero}, fetcher)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/page", nil)
+	_ = svc.Handle(context.Background(), req, httptest.NewRecorder())
+
+	if len(fetcher.tried) != 1 {
+		t.Fatalf("expected the budget to prevent retries, got %v", fetcher.tried)
+	}
+	metrics := svc.Metrics()
+	if metrics["retry_budget_skips_total"] != 1 || metrics["upstream_retries_total"] != 0 {
+		t.Fatalf("unexpected retry metrics %v", metrics)
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/robertomachorro/doormanlb/internal/cache"
+	"github.com/robertomachorro/doormanlb/internal/config"
+	"github.com/robertomachorro/doormanlb/internal/keybuilder"
+	"github.com/robertomachorro/doormanlb/internal/proxy"
+	"github.com/robertomachorro/doormanlb/internal/routing"
+)
+
+func TestConsistentHashRoutesPageToSameService(t *testing.T) {
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://svc-a": {StatusCode: http.StatusOK},
+		"http://svc-b": {StatusCode: http.StatusOK},
+		"http://svc-c": {StatusCode: http.StatusOK},
+	}}
+	svc := newThreeServiceService(t, config.StrategyConsistentHash, config.RetryConfig{}, fetcher)
+
+	for _, target := range []string{"/page?b=2&a=1", "/page?a=1&b=2", "/page?a=1&b=2"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+target, nil)
+		if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling request: %v", err)
+		}
+	}
+
+	if len(fetcher.tried) != 3 || fetcher.tried[1] != fetcher.tried[0] || fetcher.tried[2] != fetcher.tried[0] {
+		t.Fatalf("expected every fetch of the page on one service, got %v", fetcher.tried)
+	}
+}
+
+func TestEndpointsUseTheRouterOfTheirPool(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://web"}},
+		Strategy: config.StrategyRoundRobin,
+		Pools: map[string]config.PoolConfig{
+			"api": {Services: []config.Service{{URL: "http://api"}}, Strategy: config.StrategyRoundRobin},
+		},
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+			"/api":                    {Pool: "api"},
+		},
+	}
+	routers := map[string]*routing.Router{}
+	for _, name := range cfg.PoolNames() {
+		router, err := routing.NewRouter(cfg.Pool(name).ServiceURLs(), cfg.Pool(name).Strategy)
+		if err != nil {
+			t.Fatalf("creating router for %s: %v", name, err)
+		}
+		routers[name] = router
+	}
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://web": {StatusCode: http.StatusOK},
+		"http://api": {StatusCode: http.StatusOK},
+	}}
+	svc := NewPooledCachingService(cfg, routers, &fakeStore{}, fetcher)
+
+	for _, path := range []string{"/api", "/", "/api"} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+		if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling %s: %v", path, err)
+		}
+	}
+
+	if want := []string{"http://api", "http://web", "http://api"}; !reflect.DeepEqual(fetcher.tried, want) {
+		t.Fatalf("expected fetches %v, got %v", want, fetcher.tried)
+	}
+	if health := svc.UpstreamHealth(); len(health) != 2 || !health["http://web"] || !health["http://api"] {
+		t.Fatalf("expected health of both pools, got %v", health)
+	}
+}
+
+func TestVirtualHostsHaveTheirOwnServicesAndCacheKeys(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://web"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCache, ExpireTimeout: config.Milliseconds(60_000)},
+		},
+		Hosts: map[string]config.HostConfig{
+			"a.example.com": {Services: []config.Service{{URL: "http://site-a"}}},
+			"*.example.com": {Services: []config.Service{{URL: "http://site-b"}}},
+		},
+	}
+	routers := map[string]*routing.Router{}
+	for _, name := range cfg.PoolNames() {
+		router, err := routing.NewRouter(cfg.Pool(name).ServiceURLs(), cfg.Pool(name).Strategy)
+		if err != nil {
+			t.Fatalf("creating router for %s: %v", name, err)
+		}
+		routers[name] = router
+	}
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://web":    {StatusCode: http.StatusOK, Body: []byte("web")},
+		"http://site-a": {StatusCode: http.StatusOK, Body: []byte("a")},
+		"http://site-b": {StatusCode: http.StatusOK, Body: []byte("b")},
+	}}
+	svc := NewPooledCachingService(cfg, routers, cache.NewMemoryStore(0, 0), fetcher)
+
+	for _, tc := range []struct{ host, body string }{
+		{"a.example.com", "a"},
+		{"b.example.com", "b"},
+		{"example.org", "web"},
+		{"A.example.com:443", "a"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/index", nil)
+		recorder := httptest.NewRecorder()
+		if err := svc.Handle(context.Background(), req, recorder); err != nil {
+			t.Fatalf("handling %s: %v", tc.host, err)
+		}
+		if recorder.Body.String() != tc.body {
+			t.Fatalf("expected %s to serve %q, got %q", tc.host, tc.body, recorder.Body.String())
+		}
+	}
+
+	if want := []string{"http://site-a", "http://site-b", "http://web"}; !reflect.DeepEqual(fetcher.tried, want) {
+		t.Fatalf("expected one fetch per site with the repeat served from cache, got %v", fetcher.tried)
+	}
+}
+
+func TestReloadSwapsEndpointsAndRouters(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://web"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+		},
+	}
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	fetcher := &serviceFetcher{responses: map[string]*proxy.Response{
+		"http://web": {StatusCode: http.StatusOK},
+		"http://api": {StatusCode: http.StatusOK},
+	}}
+	svc := NewCachingService(cfg, router, &fakeStore{}, fetcher)
+
+	reloaded := cfg
+	reloaded.Pools = map[string]config.PoolConfig{
+		"api": {Services: []config.Service{{URL: "http://api"}}, Strategy: config.StrategyRoundRobin},
+	}
+	reloaded.Endpoints = map[string]config.EndpointConfig{
+		config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorPassthrough},
+		"/api":                    {Pool: "api"},
+	}
+	apiRouter, err := routing.NewRouter(reloaded.Pool("api").ServiceURLs(), config.StrategyRoundRobin)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+
+	request := func() {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/api", nil)
+		if err := svc.Handle(context.Background(), req, httptest.NewRecorder()); err != nil {
+			t.Fatalf("handling request: %v", err)
+		}
+	}
+	request()
+	svc.Reload(reloaded, map[string]*routing.Router{config.DefaultPoolKey: router, "api": apiRouter})
+	request()
+
+	if want := []string{"http://web", "http://api"}; !reflect.DeepEqual(fetcher.tried, want) {
+		t.Fatalf("expected fetches %v, got %v", want, fetcher.tried)
+	}
+}
+
+func TestRequestFinishesWithTheConfigItStartedWith(t *testing.T) {
+	cfg := config.Config{
+		Services: []config.Service{{URL: "http://web"}},
+		Strategy: config.StrategyRoundRobin,
+		Endpoints: map[string]config.EndpointConfig{
+			config.DefaultEndpointKey: {CacheBehavior: config.CacheBehaviorCache, ExpireTimeout: config.Milliseconds(60_000)},
+		},
+	}
+	router, err := routing.NewRouter(cfg.ServiceURLs(), cfg.Strategy)
+	if err != nil {
+		t.Fatalf("creating router: %v", err)
+	}
+	fetcher := &slowLeaderFetcher{opened: make(chan struct{}), release: make(chan struct{})}
+	store := cache.NewMemoryStore(0, 0)
+	svc := NewCachingService(cfg, router, store, fetcher)
+
+	done := make(chan error, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://a.example.com/index", nil)
+		done <- svc.Handle(context.Background(), req, httptest.NewRecorder())
+	}()
+	<-fetcher.opened
+
+	reloaded := cfg
+	reloaded.Hosts = map[string]config.HostConfig{"a.example.com": {}}
+	svc.Reload(reloaded, map[string]*routing.Router{config.DefaultPoolKey: router})
+	close(fetcher.release)
+	if err := <-done; err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+
+	key := keybuilder.Build(httptest.NewRequest(http.MethodGet, "http://a.example.com/index", nil), keybuilder.Options{})
+	cached, _ := store.Get(context.Background(), key)
+	if cached == nil || cached.Host != "" {
+		t.Fatalf("expected the response to be stored as the original config keys it, got %+v", cached)
+	}
+}